@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/gavv/webrtc-cli/src/rtc"
+)
+
+// creates two peers connected to each other, first one sends and second one
+// receives; SDP is exchanged directly instead of via stdin and stdout
+func newLoopbackPeers(params rtc.Params) (*rtc.Peer, *rtc.Peer, error) {
+	sendParams := params
+	sendParams.EnableRead = false
+
+	sender, err := rtc.NewPeer(sendParams)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recvParams := params
+	recvParams.EnableWrite = false
+	recvParams.OfferSDP = sender.GetOffer()
+
+	receiver, err := rtc.NewPeer(recvParams)
+	if err != nil {
+		sender.Close()
+		return nil, nil, err
+	}
+
+	if err := sender.SetAnswer(receiver.GetAnswer()); err != nil {
+		receiver.Close()
+		sender.Close()
+		return nil, nil, err
+	}
+
+	return sender, receiver, nil
+}
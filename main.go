@@ -9,12 +9,16 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/mattn/go-isatty"
 	"github.com/spf13/pflag"
 
 	"github.com/gavv/webrtc-cli/src/dsp"
+	"github.com/gavv/webrtc-cli/src/log"
+	"github.com/gavv/webrtc-cli/src/metrics"
+	"github.com/gavv/webrtc-cli/src/netsim"
 	"github.com/gavv/webrtc-cli/src/rtc"
 	"github.com/gavv/webrtc-cli/src/snd"
 )
@@ -24,18 +28,61 @@ const (
 	delim2 = "-------------------------->8--------------------------"
 )
 
+var mainLog = log.New("main")
+
 func main() {
 	os.Exit(mainWithCode())
 }
 
 func mainWithCode() int {
-	fset := pflag.NewFlagSet("webrtc-cli", pflag.ContinueOnError)
+	if len(os.Args) > 1 && os.Args[1] == "devices" {
+		return devicesWithCode(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		return replayWithCode(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "loopback" {
+		return runWithCode("webrtc-cli loopback", os.Args[2:], true)
+	}
+	return runWithCode("webrtc-cli", os.Args[1:], false)
+}
+
+// in loopback mode, source and sink are connected via two peers in this process
+func runWithCode(name string, args []string, loopback bool) int {
+	fset := pflag.NewFlagSet(name, pflag.ContinueOnError)
 
 	offer := fset.Bool("offer", false, "enable offer mode")
 	answer := fset.Bool("answer", false, "enable answer mode")
 
-	source := fset.String("source", "", "pulseaudio source or input wav file")
-	sink := fset.String("sink", "", "pulseaudio sink")
+	source := fset.String("source", "",
+		"pulseaudio source, alsa device (e.g. \"alsa:hw:1,0\"), \"default\","+
+			" device index (see \"webrtc-cli devices\"), input wav/flac/mp3 file, playlist,"+
+			" generator (e.g. \"tone:440\"), or RTP address (e.g. \"rtp://0.0.0.0:5004\")")
+	sink := fset.String("sink", "",
+		"pulseaudio sink, alsa device (e.g. \"alsa:hw:1,0\"), \"default\","+
+			" device index (see \"webrtc-cli devices\"), output wav file,"+
+			" or RTP address (e.g. \"rtp://127.0.0.1:5004\")")
+
+	loop := fset.Bool("loop", false, "repeat input file or playlist infinitely")
+	shuffle := fset.Bool("shuffle", false, "play playlist items in random order")
+	gap := fset.Duration("gap", 0, "insert silence of given duration between playlist items")
+	start := fset.Duration("start", 0, "start reading every input file from given position")
+	duration := fset.Duration("duration", 0, "read only given duration of every input file")
+
+	sourceSDP := fset.String("source-sdp", "",
+		"SDP file describing payload types and address of RTP source")
+	rtpTranscode := fset.Bool("rtp-transcode", false,
+		"decode and re-encode opus from RTP source instead of passing it through")
+
+	sinkSDP := fset.String("sink-sdp", "",
+		"write SDP file describing stream sent to RTP sink")
+	rtpSSRC := fset.Uint32("rtp-ssrc", 0, "rewrite SSRC of packets sent to RTP sink")
+	rtpPT := fset.Uint8("rtp-pt", 0, "rewrite payload type of packets sent to RTP sink")
+
+	recordOpus := fset.String("record-opus", "",
+		"write received opus packets to given ogg file without decoding")
+	captureRTP := fset.String("capture-rtp", "",
+		"write received RTP and RTCP packets to given pcap or rtpdump (*.rtpdump) file")
 
 	timeout := fset.Duration("timeout", 0, "exit if can't connect during timeout")
 
@@ -51,12 +98,7 @@ func mainWithCode() int {
 	channels := fset.Uint("chans", 2, "# of channels")
 
 	sourceFrame := fset.Duration("source-frame", 40*time.Millisecond, "source frame size")
-	sinkFrame := fset.Duration("sink-frame", 40*time.Millisecond, "sink frame size")
-	jitterBuf := fset.Duration("jitter-buf", 120*time.Millisecond, "jitter buffer size")
-	pulseBuf := fset.Duration("pulse-buf", 20*time.Millisecond, "pulseaudio buffer size")
-
-	maxDrift := fset.Duration("max-drift", 30*time.Millisecond,
-		"maximum jitter buffer drift")
+	sf := addSinkFlags(fset)
 
 	modeStr := fset.String("mode", "voip", "opus encoder mode: voip|audio|lowdelay")
 
@@ -68,11 +110,34 @@ func mainWithCode() int {
 	simLossPerc := fset.Uint("simulate-loss-perc", 0,
 		"simulate given loss percent when receiving packets")
 
-	debug := fset.Bool("debug", false, "enable more logs")
+	impairRecv := fset.String("impair-recv", "",
+		"simulate network impairments when receiving packets"+
+			" (e.g. \"burst=5:30,delay=50ms,jitter=20ms,reorder=1,dup=1,rate=64k\")")
+	impairSend := fset.String("impair-send", "",
+		"simulate network impairments when sending packets, same format as --impair-recv")
+	impairScript := fset.String("impair-script", "",
+		"yaml file with impairment changes over session time")
+	impairSeed := fset.Int64("impair-seed", 0,
+		"random seed for simulated impairments (0 to choose randomly)")
+
+	reorderWindow := fset.Duration("reorder-window", 0,
+		"how long to wait for out-of-order packets before decoding (0 to disable)")
+
+	statsInterval := fset.Duration("stats-interval", 0,
+		"print RTCP-based call statistics with given interval (0 to disable)")
+
+	metricsAddr := fset.String("metrics-addr", "",
+		"serve prometheus metrics on given address (e.g. \":9100\")")
+
+	logFormat := fset.String("log-format", "text", "log format: text|json")
+	logLevel := fset.String("log-level", "info",
+		"log level: debug|info|warn|error, optionally per subsystem (e.g. \"warn,jitbuf=debug\")")
+
+	debug := fset.Bool("debug", false, "enable debug logs, same as --log-level debug")
 
 	fset.SortFlags = false
 
-	if err := fset.Parse(os.Args[1:]); err != nil {
+	if err := fset.Parse(args); err != nil {
 		if err == pflag.ErrHelp {
 			return 0
 		}
@@ -80,7 +145,31 @@ func mainWithCode() int {
 		return 1
 	}
 
-	if *offer == *answer {
+	if err := configureLogging(*logFormat, *logLevel, *debug); err != nil {
+		printErr(err)
+		return 1
+	}
+
+	if loopback {
+		if *offer || *answer {
+			printErrMsg("--offer and --answer are not used in loopback mode")
+			return 1
+		}
+
+		if *source == "" || (*sink == "" && *recordOpus == "" && *captureRTP == "") {
+			printErrMsg("loopback mode requires --source, and --sink, --record-opus," +
+				" or --capture-rtp")
+			return 1
+		}
+
+		if fset.Changed("ice") || fset.Changed("stun") {
+			printErrMsg("--ice and --stun are not used in loopback mode")
+			return 1
+		}
+
+		// peers are connected using host candidates
+		*ice = ""
+	} else if *offer == *answer {
 		printErrMsg("exactly one of --offer and --answer options should be specified")
 		return 1
 	}
@@ -136,23 +225,113 @@ func mainWithCode() int {
 		return 1
 	}
 
+	if fset.Changed("impair-recv") && *sink == "" {
+		printErrMsg("--impair-recv is only meaningful when --sink is given")
+		return 1
+	}
+
+	if fset.Changed("impair-send") && *source == "" {
+		printErrMsg("--impair-send is only meaningful when --source is given")
+		return 1
+	}
+
+	if fset.Changed("impair-script") && *sink == "" && *source == "" {
+		printErrMsg("--impair-script is only meaningful when --sink or --source is given")
+		return 1
+	}
+
+	if fset.Changed("impair-seed") && *impairRecv == "" && *impairSend == "" &&
+		*impairScript == "" && *simLossPerc == 0 {
+		printErrMsg("--impair-seed is only meaningful when --impair-recv," +
+			" --impair-send, --impair-script, or --simulate-loss-perc is given")
+		return 1
+	}
+
+	recvImpairment, err := netsim.ParseParams(*impairRecv)
+	if err != nil {
+		printErrMsg("invalid --impair-recv: " + err.Error())
+		return 1
+	}
+
+	sendImpairment, err := netsim.ParseParams(*impairSend)
+	if err != nil {
+		printErrMsg("invalid --impair-send: " + err.Error())
+		return 1
+	}
+
+	if *simLossPerc != 0 {
+		if recvImpairment.LossPercent != 0 {
+			printErrMsg("--simulate-loss-perc and loss in --impair-recv" +
+				" should not be used together")
+			return 1
+		}
+		recvImpairment.LossPercent = float64(*simLossPerc)
+	}
+
+	if *impairSeed == 0 {
+		*impairSeed = time.Now().UnixNano()
+	}
+	recvImpairment.Seed = *impairSeed
+	sendImpairment.Seed = *impairSeed + 1
+
+	var impairmentScript netsim.Script
+	if *impairScript != "" {
+		impairmentScript, err = netsim.LoadScript(
+			*impairScript, recvImpairment, sendImpairment)
+		if err != nil {
+			printErr(err)
+			return 1
+		}
+	}
+
+	if fset.Changed("reorder-window") && (*sink == "" || rtc.IsRTPAddress(*sink)) {
+		printErrMsg("--reorder-window is only meaningful when --sink is given" +
+			" and is not an RTP address")
+		return 1
+	}
+
+	if *reorderWindow < 0 {
+		printErrMsg("--reorder-window should not be negative")
+		return 1
+	}
+
 	if fset.Changed("source-frame") && *source == "" {
 		printErrMsg("--source-frame is only meaningful when --source is given")
 		return 1
 	}
 
-	if fset.Changed("sink-frame") && *sink == "" {
-		printErrMsg("--sink-frame is only meaningful when --sink is given")
+	if *start < 0 || *duration < 0 || *gap < 0 {
+		printErrMsg("--start, --duration, and --gap should not be negative")
+		return 1
+	}
+
+	if (*loop || *shuffle || fset.Changed("gap") ||
+		fset.Changed("start") || fset.Changed("duration")) && *source == "" {
+		printErrMsg("--loop, --shuffle, --gap, --start, and --duration" +
+			" are only meaningful when --source is given")
 		return 1
 	}
 
-	if fset.Changed("jitter-buf") && *sink == "" {
-		printErrMsg("--jitter-buf is only meaningful when --sink is given")
+	if (*sourceSDP != "" || *rtpTranscode) && !rtc.IsRTPAddress(*source) {
+		printErrMsg("--source-sdp and --rtp-transcode are only meaningful" +
+			" when --source is an RTP address")
 		return 1
 	}
 
-	if fset.Changed("max-drift") && *sink == "" {
-		printErrMsg("--max-drift is only meaningful when --sink is given")
+	if (*sinkSDP != "" || fset.Changed("rtp-ssrc") || fset.Changed("rtp-pt")) &&
+		!rtc.IsRTPAddress(*sink) {
+		printErrMsg("--sink-sdp, --rtp-ssrc, and --rtp-pt are only meaningful" +
+			" when --sink is an RTP address")
+		return 1
+	}
+
+	if *rtpPT > 127 {
+		printErrMsg("--rtp-pt should be in [0; 127]")
+		return 1
+	}
+
+	if err := sf.validate(fset, *sink != ""); err != nil {
+		printErrMsg(err.Error())
 		return 1
 	}
 
@@ -166,48 +345,105 @@ func mainWithCode() int {
 	}
 
 	rtcParams := rtc.Params{
-		IceURL:              *ice,
-		MinPort:             minPort,
-		MaxPort:             maxPort,
-		OverrideIP:          *overrideIP,
-		EnableWrite:         *source != "",
-		EnableRead:          *sink != "",
-		Rate:                int(*rate),
-		Channels:            int(*channels),
-		Mode:                mode,
-		Complexity:          int(*complexity),
-		LossPercent:         int(*lossPerc),
-		SimulateLossPercent: int(*simLossPerc),
-		Debug:               *debug,
-	}
-
-	if *answer {
-		printMsg("Reading SDP offer from stdin...")
-		var err error
-		rtcParams.OfferSDP, err = readSDP()
+		IceURL:            *ice,
+		MinPort:           minPort,
+		MaxPort:           maxPort,
+		OverrideIP:        *overrideIP,
+		EnableWrite:       *source != "",
+		EnableRead:        *sink != "" || *recordOpus != "" || *captureRTP != "",
+		Rate:              int(*rate),
+		Channels:          int(*channels),
+		Mode:              mode,
+		Complexity:        int(*complexity),
+		LossPercent:       int(*lossPerc),
+		ReceiveImpairment: recvImpairment,
+		SendImpairment:    sendImpairment,
+		ImpairmentScript:  impairmentScript,
+		ReorderWindow:     *reorderWindow,
+		RecordOpus:        *recordOpus,
+		CaptureRTP:        *captureRTP,
+		Debug:             *debug,
+	}
+
+	// peers used by source and sink, same peer unless in loopback mode
+	var srcPeer, sinkPeer *rtc.Peer
+
+	if loopback {
+		printMsg("Creating loopback WebRTC peers...")
+
+		srcPeer, sinkPeer, err = newLoopbackPeers(rtcParams)
+		if err != nil {
+			printErr(err)
+			return 1
+		}
+	} else {
+		if *answer {
+			printMsg("Reading SDP offer from stdin...")
+			var err error
+			rtcParams.OfferSDP, err = readSDP()
+			if err != nil {
+				printErr(err)
+				return 1
+			}
+		}
+
+		printMsg("Creating WebRTC peer...")
+
+		srcPeer, err = rtc.NewPeer(rtcParams)
 		if err != nil {
 			printErr(err)
 			return 1
 		}
+
+		sinkPeer = srcPeer
 	}
 
-	printMsg("Creating WebRTC peer...")
+	peers := []*rtc.Peer{srcPeer}
+	if sinkPeer != srcPeer {
+		peers = append(peers, sinkPeer)
+	}
 
-	peer, err := rtc.NewPeer(rtcParams)
-	if err != nil {
-		printErr(err)
-		return 1
+	// peers are named only in loopback mode
+	peerNames := []string{""}
+	if loopback {
+		peerNames = []string{"sending", "receiving"}
+	}
+
+	sources := &statsSources{
+		peers:     peers,
+		peerNames: peerNames,
+		sinkFrame: *sf.sinkFrame,
 	}
 
 	defer func() {
-		if err := peer.Close(); err != nil {
-			printErr(err)
+		for _, peer := range peers {
+			if err := peer.Close(); err != nil {
+				printErr(err)
+			}
+		}
+		for _, peer := range peers {
+			for _, summary := range peer.ImpairmentSummary() {
+				mainLog.Info("impairment_summary", nil, "%s", summary)
+			}
+		}
+		for n := range peers {
+			printReport(sources.peerStats(n, true), peerNames[n])
 		}
 	}()
 
+	if *metricsAddr != "" {
+		server, err := metrics.NewServer(*metricsAddr, sources.collect)
+		if err != nil {
+			printErr(err)
+			return 1
+		}
+
+		defer server.Close()
+	}
+
 	if *offer {
 		printMsg("Writing SDP offer to stdout...")
-		err := printSDP(peer.GetOffer())
+		err := printSDP(srcPeer.GetOffer())
 		if err != nil {
 			printErr(err)
 			return 1
@@ -219,13 +455,13 @@ func mainWithCode() int {
 			printErr(err)
 			return 1
 		}
-		if err := peer.SetAnswer(answer); err != nil {
+		if err := srcPeer.SetAnswer(answer); err != nil {
 			printErr(err)
 			return 1
 		}
-	} else {
+	} else if *answer {
 		printMsg("Writing SDP answer to stdout...")
-		err := printSDP(peer.GetAnswer())
+		err := printSDP(srcPeer.GetAnswer())
 		if err != nil {
 			printErr(err)
 			return 1
@@ -238,41 +474,92 @@ func mainWithCode() int {
 	errCh := make(chan error, 32)
 	eofCh := make(chan struct{})
 
-	go func() {
-		var state rtc.State
-		var timeoutCh <-chan time.Time
+	for n, peer := range peers {
+		name := peerNames[n]
+
+		go func(peer *rtc.Peer, name string) {
+			var state rtc.State
+			var timeoutCh <-chan time.Time
+
+			for {
+				if state.IsConnected() {
+					timeoutCh = nil
+				} else if *timeout > 0 && timeoutCh == nil {
+					timeoutCh = time.After(*timeout)
+				}
 
-		for {
-			if state.IsConnected() {
-				timeoutCh = nil
-			} else if *timeout > 0 && timeoutCh == nil {
-				timeoutCh = time.After(*timeout)
+				select {
+				case newState, ok := <-peer.State():
+					if !ok {
+						// peer is closed
+						return
+					}
+					state = newState
+					mainLog.Info("ice_state_changed",
+						peerFields(name, log.Fields{"state": state.String()}),
+						"ICE connection state changed to %s%s", state, peerLabel(name))
+
+				case <-timeoutCh:
+					errCh <- fmt.Errorf("can't connect to remote peer during %s", *timeout)
+					return
+				}
 			}
+		}(peer, name)
+	}
 
-			select {
-			case state = <-peer.State():
-				printMsg("ICE connection state changed to " + state.String())
+	if *statsInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(*statsInterval)
+			defer ticker.Stop()
 
-			case <-timeoutCh:
-				errCh <- fmt.Errorf("can't connect to remote peer during %s", *timeout)
-				return
+			for range ticker.C {
+				for n := range peers {
+					printStats(sources.peerStats(n, false), peerNames[n])
+				}
 			}
+		}()
+	}
+
+	if rtc.IsRTPAddress(*source) {
+		printMsg("Starting RTP receiver...")
+
+		rtpSource, err := rtc.NewRTPSource(rtc.RTPSourceParams{
+			URL:         *source,
+			SDPFile:     *sourceSDP,
+			Transcode:   *rtpTranscode,
+			Rate:        int(*rate),
+			Channels:    int(*channels),
+			FrameLength: *sourceFrame,
+			Debug:       *debug,
+		}, srcPeer)
+		if err != nil {
+			printErr(err)
+			return 1
 		}
-	}()
 
-	if err := snd.SetPulseBufferSize(*pulseBuf); err != nil {
-		printErr(err)
-		return 1
-	}
+		defer rtpSource.Close()
 
-	if *source != "" {
+		go func() {
+			for err := range rtpSource.Errors() {
+				errCh <- err
+			}
+		}()
+	} else if *source != "" {
 		printMsg("Starting recording...")
 
 		reader, err := snd.NewReader(snd.Params{
-			DeviceOrFile: *source,
-			Rate:         int(*rate),
-			Channels:     int(*channels),
-			FrameLength:  *sourceFrame,
+			DeviceOrFile:      *source,
+			Rate:              int(*rate),
+			Channels:          int(*channels),
+			FrameLength:       *sourceFrame,
+			BufferLength:      *sf.alsaBuf,
+			PeriodLength:      *sf.alsaPeriod,
+			PulseBufferLength: *sf.pulseBuf,
+			Loop:              *loop,
+			Shuffle:           *shuffle,
+			Start:             *start,
+			Duration:          *duration,
+			Gap:               *gap,
 		})
 		if err != nil {
 			printErr(err)
@@ -288,7 +575,7 @@ func mainWithCode() int {
 					return
 				}
 
-				err := peer.Write(b.Data)
+				err := srcPeer.Write(b.Data)
 				if err != nil {
 					errCh <- err
 					return
@@ -299,13 +586,51 @@ func mainWithCode() int {
 		}()
 	}
 
-	if *sink != "" {
+	// in loopback mode, after source EOF, wait until last packets pass
+	// impairments and reorder window, and samples reach sink
+	drainTime := func() time.Duration {
+		return sendImpairment.Delay + sendImpairment.Jitter +
+			recvImpairment.Delay + recvImpairment.Jitter +
+			*reorderWindow + 2*(*sourceFrame)
+	}
+
+	if rtc.IsRTPAddress(*sink) {
+		printMsg("Starting RTP forwarding...")
+
+		rtpPayloadType := -1
+		if fset.Changed("rtp-pt") {
+			rtpPayloadType = int(*rtpPT)
+		}
+
+		rtpSink, err := rtc.NewRTPSink(rtc.RTPSinkParams{
+			URL:         *sink,
+			SDPFile:     *sinkSDP,
+			SSRC:        *rtpSSRC,
+			PayloadType: rtpPayloadType,
+			Debug:       *debug,
+		}, sinkPeer)
+		if err != nil {
+			printErr(err)
+			return 1
+		}
+
+		defer rtpSink.Close()
+
+		go func() {
+			for err := range rtpSink.Errors() {
+				errCh <- err
+			}
+		}()
+	} else if *sink != "" {
 		printMsg("Starting playback...")
 
-		player, err := snd.NewPulsePlayer(snd.Params{
-			DeviceOrFile: *sink,
-			Rate:         int(*rate),
-			Channels:     int(*channels),
+		player, err := snd.NewWriter(snd.Params{
+			DeviceOrFile:      *sink,
+			Rate:              int(*rate),
+			Channels:          int(*channels),
+			BufferLength:      *sf.alsaBuf,
+			PeriodLength:      *sf.alsaPeriod,
+			PulseBufferLength: *sf.pulseBuf,
 		})
 		if err != nil {
 			printErr(err)
@@ -320,14 +645,11 @@ func mainWithCode() int {
 			}
 		}()
 
-		jitbuf, err := dsp.NewJitterBuf(dsp.JitterBufParams{
-			Rate:         int(*rate),
-			Channels:     int(*channels),
-			FrameLength:  *sinkFrame,
-			BufferLength: *jitterBuf,
-			MaxDrift:     *maxDrift,
-			Debug:        *debug,
-		})
+		jitbufParams := sf.jitterBufParams(*rate, *channels, *debug)
+		jitbufParams.Conceal = sinkPeer.Conceal
+		jitbufParams.Extrapolate = sinkPeer.Extrapolate
+
+		jitbuf, err := dsp.NewJitterBuf(jitbufParams)
 		if err != nil {
 			printErr(err)
 			return 1
@@ -335,15 +657,23 @@ func mainWithCode() int {
 
 		defer jitbuf.Stop()
 
+		sources.setJitterBuf(jitbuf)
+
+		netDrainTime := drainTime
+		drainTime = func() time.Duration {
+			return netDrainTime() +
+				jitbuf.Stats().TargetLength + *sf.maxDrift + 2*(*sf.sinkFrame)
+		}
+
 		go func() {
 			for {
-				samples, err := peer.Read()
+				samples, timestamp, err := sinkPeer.ReadWithTimestamp()
 				if err != nil {
 					errCh <- err
 					return
 				}
 
-				jitbuf.Write(samples)
+				jitbuf.WriteWithTimestamp(samples, timestamp)
 			}
 		}()
 
@@ -362,21 +692,117 @@ func mainWithCode() int {
 				}
 			}
 		}()
+	} else if *recordOpus != "" || *captureRTP != "" {
+		go func() {
+			for {
+				// packets are recorded by peer, decoded samples are not needed
+				if _, err := sinkPeer.ReadRTP(); err != nil {
+					errCh <- err
+					return
+				}
+			}
+		}()
 	}
 
-	select {
-	case <-eofCh:
-		printMsg("Got EOF, exiting")
-		return 0
+	var drainCh <-chan time.Time
+
+	for {
+		select {
+		case <-eofCh:
+			if !loopback {
+				printMsg("Got EOF, exiting")
+				return 0
+			}
+			printMsg("Got EOF, waiting for receiver...")
+			eofCh = nil
+			drainCh = time.After(drainTime())
+
+		case <-drainCh:
+			printMsg("Receiver drained, exiting")
+			return 0
 
-	case <-sigCh:
-		printMsg("Got interrupt, exiting")
-		return 0
+		case <-sigCh:
+			printMsg("Got interrupt, exiting")
+			return 0
 
-	case err := <-errCh:
+		case err := <-errCh:
+			printErr(err)
+			return 1
+		}
+	}
+}
+
+func devicesWithCode(args []string) int {
+	fset := pflag.NewFlagSet("webrtc-cli devices", pflag.ContinueOnError)
+
+	if err := fset.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return 0
+		}
 		printErr(err)
 		return 1
 	}
+
+	if fset.NArg() != 0 {
+		printErrMsg("unexpected arguments: " + strings.Join(fset.Args(), " "))
+		return 1
+	}
+
+	for _, capture := range []bool{true, false} {
+		devices, errs := snd.ListDevices(capture)
+
+		for _, err := range errs {
+			mainLog.Warn("device_list_failed", log.Fields{"error": err.Error()},
+				"%s", err.Error())
+		}
+
+		if capture {
+			fmt.Println("Sources (use with --source):")
+		} else {
+			fmt.Println("Sinks (use with --sink):")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+		fmt.Fprintln(w, "  INDEX\tNAME\tFORMATS\tRATE\tCHANS\tDESCRIPTION")
+
+		for n, dev := range devices {
+			index := strconv.Itoa(n)
+			if dev.Default {
+				index += "*"
+			}
+
+			formats := "-"
+			if len(dev.Formats) != 0 {
+				formats = strings.Join(dev.Formats, ",")
+			}
+
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\t%s\n",
+				index, dev.Name, formats,
+				formatRange(dev.MinRate, dev.MaxRate),
+				formatRange(dev.MinChannels, dev.MaxChannels),
+				dev.Description)
+		}
+
+		w.Flush()
+
+		if capture {
+			fmt.Println()
+		}
+	}
+
+	return 0
+}
+
+func formatRange(min, max int) string {
+	switch {
+	case max == 0:
+		return "-"
+	case min == max:
+		return strconv.Itoa(min)
+	default:
+		return fmt.Sprintf("%d-%d", min, max)
+	}
 }
 
 func parsePorts(s string) (uint16, uint16, error) {
@@ -410,6 +836,17 @@ func parsePorts(s string) (uint16, uint16, error) {
 	return uint16(minPort), uint16(maxPort), nil
 }
 
+func parseJitterStrategy(s string) (dsp.Strategy, error) {
+	switch s {
+	case "reset":
+		return dsp.StrategyReset, nil
+	case "stretch":
+		return dsp.StrategyStretch, nil
+	default:
+		return dsp.Strategy(-1), errors.New("should be reset|stretch")
+	}
+}
+
 func parseMode(s string) (rtc.Mode, error) {
 	switch s {
 	case "voip":
@@ -462,14 +899,39 @@ func printSDP(sdp string) error {
 	return nil
 }
 
+// --debug lowers default level to debug, subsystem levels are kept
+func configureLogging(format, levels string, debug bool) error {
+	logFormat, err := log.ParseFormat(format)
+	if err != nil {
+		return fmt.Errorf("invalid --log-format: %s", err.Error())
+	}
+
+	defLevel, subLevels, err := log.ParseLevels(levels)
+	if err != nil {
+		return fmt.Errorf("invalid --log-level: %s", err.Error())
+	}
+
+	if debug {
+		defLevel = log.LevelDebug
+	}
+
+	log.Configure(log.Config{
+		Format:          logFormat,
+		Level:           defLevel,
+		SubsystemLevels: subLevels,
+	})
+
+	return nil
+}
+
 func printErr(err error) {
 	printErrMsg(err.Error())
 }
 
 func printErrMsg(s string) {
-	printMsg("Error: " + s)
+	mainLog.Error("error", nil, "%s", s)
 }
 
 func printMsg(s string) {
-	fmt.Fprintln(os.Stderr, s)
+	mainLog.Info("", nil, "%s", s)
 }
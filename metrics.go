@@ -0,0 +1,129 @@
+package main
+
+import (
+	"github.com/gavv/webrtc-cli/src/dsp"
+	"github.com/gavv/webrtc-cli/src/metrics"
+	"github.com/gavv/webrtc-cli/src/quality"
+	"github.com/gavv/webrtc-cli/src/rtc"
+)
+
+const metricsPrefix = "webrtc_cli_"
+
+var iceStates = []string{
+	"new", "checking", "connected", "completed", "disconnected", "failed", "closed",
+}
+
+func (s *statsSources) collect(w *metrics.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for n, peer := range s.peers {
+		// peers are labeled only in loopback mode
+		var labels []metrics.Label
+		if s.peerNames[n] != "" {
+			labels = append(labels, metrics.Label{Name: "peer", Value: s.peerNames[n]})
+		}
+
+		collectPeer(w, peer, s.peerStatsLocked(n, false), labels)
+	}
+
+	if s.jitbuf != nil {
+		collectJitterBuf(w, s.jitbuf.Stats())
+	}
+}
+
+func collectPeer(
+	w *metrics.Writer, peer *rtc.Peer, stats peerStats, labels []metrics.Label,
+) {
+	with := func(name, value string) []metrics.Label {
+		return append(append([]metrics.Label(nil), labels...),
+			metrics.Label{Name: name, Value: value})
+	}
+
+	state := peer.CurrentState().String()
+	for _, s := range iceStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		w.Add(metrics.Gauge, metricsPrefix+"ice_state",
+			"Current ICE connection state (1 for current state)",
+			value, with("state", s)...)
+	}
+
+	for _, dir := range []struct {
+		name    string
+		stats   rtc.StreamStats
+		quality quality.Score
+		known   bool
+	}{
+		{"send", stats.Send, stats.SendQuality, stats.Send.Reported},
+		{"receive", stats.Receive, stats.ReceiveQuality, stats.Receive.Packets != 0},
+	} {
+		w.Add(metrics.Counter, metricsPrefix+"rtp_packets_total",
+			"RTP packets sent or received",
+			float64(dir.stats.Packets), with("direction", dir.name)...)
+		w.Add(metrics.Counter, metricsPrefix+"rtp_bytes_total",
+			"RTP payload bytes sent or received",
+			float64(dir.stats.Bytes), with("direction", dir.name)...)
+		w.Add(metrics.Gauge, metricsPrefix+"rtp_lost_packets",
+			"Cumulative number of lost packets, as reported by receiver",
+			float64(dir.stats.CumulativeLost), with("direction", dir.name)...)
+		w.Add(metrics.Gauge, metricsPrefix+"rtp_jitter_seconds",
+			"Interarrival jitter, as reported by receiver",
+			dir.stats.Jitter.Seconds(), with("direction", dir.name)...)
+
+		// not exported until there are measurements to estimate from
+		if dir.known {
+			w.Add(metrics.Gauge, metricsPrefix+"r_factor",
+				"Estimated E-model R-factor of the stream",
+				dir.quality.R, with("direction", dir.name)...)
+			w.Add(metrics.Gauge, metricsPrefix+"mos",
+				"Estimated mean opinion score of the stream",
+				dir.quality.MOS, with("direction", dir.name)...)
+		}
+	}
+
+	w.Add(metrics.Gauge, metricsPrefix+"rtt_seconds",
+		"Round-trip time computed from RTCP reports, zero if unknown",
+		stats.RTT.Seconds(), labels...)
+
+	decStats := peer.DecoderStats()
+
+	w.Add(metrics.Counter, metricsPrefix+"lost_samples_total",
+		"Samples missing in received stream because of lost or late packets",
+		float64(decStats.LostSamples), labels...)
+	w.Add(metrics.Counter, metricsPrefix+"late_samples_total",
+		"Samples concealed because no packets arrived in time for playback",
+		float64(decStats.LateSamples), labels...)
+	w.Add(metrics.Counter, metricsPrefix+"recovered_samples_total",
+		"Missing samples restored using FEC or generated using PLC",
+		float64(decStats.FECSamples), with("method", "fec")...)
+	w.Add(metrics.Counter, metricsPrefix+"recovered_samples_total",
+		"Missing samples restored using FEC or generated using PLC",
+		float64(decStats.PLCSamples), with("method", "plc")...)
+}
+
+func collectJitterBuf(w *metrics.Writer, stats dsp.JitterBufStats) {
+	w.Add(metrics.Gauge, metricsPrefix+"jitter_buffer_length_seconds",
+		"Current jitter buffer fill level",
+		stats.Length.Seconds())
+	w.Add(metrics.Gauge, metricsPrefix+"jitter_buffer_target_seconds",
+		"Target jitter buffer length",
+		stats.TargetLength.Seconds())
+	w.Add(metrics.Counter, metricsPrefix+"jitter_buffer_resets_total",
+		"Jitter buffer resets",
+		float64(stats.Resets))
+	w.Add(metrics.Counter, metricsPrefix+"jitter_buffer_stretches_total",
+		"Jitter buffer time-stretches",
+		float64(stats.Stretches))
+	w.Add(metrics.Counter, metricsPrefix+"jitter_buffer_dropped_samples_total",
+		"Samples dropped by jitter buffer because they were outdated",
+		float64(stats.DroppedSamples))
+	w.Add(metrics.Counter, metricsPrefix+"jitter_buffer_zero_samples_total",
+		"Zero samples inserted by jitter buffer instead of missing samples",
+		float64(stats.InsertedZeros))
+	w.Add(metrics.Counter, metricsPrefix+"jitter_buffer_concealed_samples_total",
+		"Samples generated by jitter buffer using PLC",
+		float64(stats.ConcealedSamples))
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/gavv/webrtc-cli/src/dsp"
+	"github.com/gavv/webrtc-cli/src/log"
+	"github.com/gavv/webrtc-cli/src/rtc"
+	"github.com/gavv/webrtc-cli/src/snd"
+)
+
+// virtual time of replayed capture, used as packet arrival time
+type replayClock struct {
+	now time.Time
+}
+
+func (c *replayClock) Now() time.Time {
+	return c.now
+}
+
+func replayWithCode(args []string) int {
+	fset := pflag.NewFlagSet("webrtc-cli replay", pflag.ContinueOnError)
+
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: webrtc-cli replay [options] <capture.pcap|capture.rtpdump>\n\n")
+		fmt.Fprintf(os.Stderr, "Options:\n%s", fset.FlagUsages())
+	}
+
+	sink := fset.String("sink", "",
+		"output wav file, pulseaudio sink, alsa device (e.g. \"alsa:hw:1,0\"), \"default\","+
+			" or device index (see \"webrtc-cli devices\")")
+
+	fast := fset.Bool("fast", false,
+		"replay as fast as possible instead of using original timing")
+
+	rate := fset.Uint("rate", 48000, "sample rate")
+	channels := fset.Uint("chans", 2, "# of channels")
+
+	sf := addSinkFlags(fset)
+
+	reorderWindow := fset.Duration("reorder-window", 0,
+		"how long to wait for out-of-order packets before decoding (0 to disable)")
+
+	logFormat := fset.String("log-format", "text", "log format: text|json")
+	logLevel := fset.String("log-level", "info",
+		"log level: debug|info|warn|error, optionally per subsystem (e.g. \"warn,jitbuf=debug\")")
+
+	debug := fset.Bool("debug", false, "enable debug logs, same as --log-level debug")
+
+	fset.SortFlags = false
+
+	if err := fset.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return 0
+		}
+		printErr(err)
+		return 1
+	}
+
+	if err := configureLogging(*logFormat, *logLevel, *debug); err != nil {
+		printErr(err)
+		return 1
+	}
+
+	if fset.NArg() != 1 {
+		printErrMsg("exactly one capture file should be specified")
+		return 1
+	}
+
+	if *sink == "" {
+		printErrMsg("--sink should be specified")
+		return 1
+	}
+
+	if *rate != 48000 && *rate != 96000 {
+		printErrMsg("--rate should be 48000 or 96000")
+		return 1
+	}
+
+	if *channels != 1 && *channels != 2 {
+		printErrMsg("--chans should be 1 or 2")
+		return 1
+	}
+
+	if err := sf.validate(fset, true); err != nil {
+		printErrMsg(err.Error())
+		return 1
+	}
+
+	if *reorderWindow < 0 {
+		printErrMsg("--reorder-window should not be negative")
+		return 1
+	}
+
+	capture, err := rtc.OpenCapture(fset.Arg(0))
+	if err != nil {
+		printErr(err)
+		return 1
+	}
+
+	defer capture.Close()
+
+	decoder, err := rtc.NewStreamDecoder(int(*rate), int(*channels), *reorderWindow, *debug)
+	if err != nil {
+		printErr(err)
+		return 1
+	}
+
+	player, err := snd.NewWriter(snd.Params{
+		DeviceOrFile:      *sink,
+		Rate:              int(*rate),
+		Channels:          int(*channels),
+		BufferLength:      *sf.alsaBuf,
+		PeriodLength:      *sf.alsaPeriod,
+		PulseBufferLength: *sf.pulseBuf,
+		// timing is controlled by replay
+		Unpaced: true,
+	})
+	if err != nil {
+		printErr(err)
+		return 1
+	}
+
+	playerStopped := false
+	defer func() {
+		if !playerStopped {
+			player.Stop()
+		}
+	}()
+
+	clock := &replayClock{}
+
+	jitbufParams := sf.jitterBufParams(*rate, *channels, *debug)
+	jitbufParams.Clock = clock
+	jitbufParams.Conceal = decoder.Conceal
+	jitbufParams.Extrapolate = decoder.Extrapolate
+
+	jitbuf, err := dsp.NewJitterBuf(jitbufParams)
+	if err != nil {
+		printErr(err)
+		return 1
+	}
+
+	defer jitbuf.Stop()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	pkt, err := capture.ReadPacket()
+	if err == io.EOF {
+		printErrMsg("capture file has no RTP packets")
+		return 1
+	}
+	if err != nil {
+		printErr(err)
+		return 1
+	}
+
+	printMsg("Starting replay...")
+
+	var (
+		nPackets int
+		nRTCP    int
+
+		// packets, releases of reordered packets, and sink reads are processed
+		// in order of their virtual time; reads start together with first packet
+		// and continue after the last one until jitter buffer is drained
+		start    = pkt.Arrival
+		readTime = pkt.Arrival
+		endTime  time.Time
+
+		wallStart = time.Now()
+	)
+
+	// decodes packets released by reorder buffer
+	decodeReleased := func() error {
+		for {
+			samples, timestamp, ok, err := decoder.Next(clock.now)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+
+			if len(samples) != 0 {
+				jitbuf.WriteWithTimestamp(samples, timestamp)
+				nPackets++
+			}
+		}
+	}
+
+	for {
+		isRead := pkt.Data == nil || readTime.Before(pkt.Arrival)
+
+		if isRead {
+			clock.now = readTime
+		} else {
+			clock.now = pkt.Arrival
+		}
+
+		isRelease := false
+		if deadline, ok := decoder.Deadline(); ok && deadline.Before(clock.now) {
+			clock.now = deadline
+			isRelease = true
+		}
+
+		if pkt.Data == nil && !isRelease && !clock.now.Before(endTime) {
+			break
+		}
+
+		if !*fast {
+			// no waiting if capture timestamps go backwards
+			if wait := time.Until(wallStart.Add(clock.now.Sub(start))); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-sigCh:
+					printMsg("Got interrupt, exiting")
+					return 0
+				}
+			}
+		}
+
+		select {
+		case <-sigCh:
+			printMsg("Got interrupt, exiting")
+			return 0
+		default:
+		}
+
+		if isRelease {
+			if err := decodeReleased(); err != nil {
+				printErr(err)
+				return 1
+			}
+			continue
+		}
+
+		if isRead {
+			samples, err := jitbuf.Read()
+			if err != nil {
+				printErr(err)
+				return 1
+			}
+
+			select {
+			case player.Batches() <- samples:
+			case err := <-player.Errors():
+				printErr(err)
+				return 1
+			case <-sigCh:
+				printMsg("Got interrupt, exiting")
+				return 0
+			}
+
+			readTime = readTime.Add(*sf.sinkFrame)
+			continue
+		}
+
+		if pkt.IsRTCP {
+			nRTCP++
+		} else {
+			if err := decoder.Push(pkt.Data, clock.now); err != nil {
+				printErr(err)
+				return 1
+			}
+
+			if err := decodeReleased(); err != nil {
+				printErr(err)
+				return 1
+			}
+		}
+
+		lastArrival := pkt.Arrival
+
+		pkt, err = capture.ReadPacket()
+		if err == io.EOF {
+			// let buffered samples and fade out reach the sink
+			endTime = lastArrival.Add(*reorderWindow +
+				jitbuf.Stats().TargetLength + *sf.maxDrift + 2*(*sf.sinkFrame))
+			pkt = rtc.CapturedPacket{}
+		} else if err != nil {
+			printErr(err)
+			return 1
+		}
+	}
+
+	// make sure file is finalized
+	playerStopped = true
+	player.Stop()
+
+	for err := range player.Errors() {
+		printErr(err)
+		return 1
+	}
+
+	stats := jitbuf.Stats()
+
+	mainLog.Info("replay_finished", log.Fields{
+		"rtp_packets":  nPackets,
+		"rtcp_packets": nRTCP,
+		"duration_ms":  log.Millis(clock.now.Sub(start)),
+	}, "Replayed %d RTP packets (and %d RTCP packets) in %s of capture time",
+		nPackets, nRTCP, clock.now.Sub(start).Round(time.Millisecond))
+
+	mainLog.Info("jitbuf_summary", log.Fields{
+		"resets":            stats.Resets,
+		"stretches":         stats.Stretches,
+		"dropped_samples":   stats.DroppedSamples,
+		"zero_samples":      stats.InsertedZeros,
+		"concealed_samples": stats.ConcealedSamples,
+		"target_ms":         log.Millis(stats.TargetLength),
+		"jitter_ms":         log.Millis(stats.Jitter),
+	}, "Jitter buffer: %d resets, %d stretches, %d dropped samples,"+
+		" %d zero samples, %d concealed samples, target length %s, jitter %s",
+		stats.Resets, stats.Stretches, stats.DroppedSamples,
+		stats.InsertedZeros, stats.ConcealedSamples,
+		stats.TargetLength, stats.Jitter.Round(time.Microsecond))
+
+	return 0
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/gavv/webrtc-cli/src/dsp"
+)
+
+// opus RTP timestamps use 48kHz clock regardless of sample rate, see RFC 7587
+const opusClockRate = 48000
+
+// playback and jitter buffer options, shared by live session and replay
+type sinkFlags struct {
+	sinkFrame  *time.Duration
+	jitterBuf  *time.Duration
+	pulseBuf   *time.Duration
+	alsaBuf    *time.Duration
+	alsaPeriod *time.Duration
+	maxDrift   *time.Duration
+
+	jitterAdaptive *bool
+	jitterMin      *time.Duration
+	jitterMax      *time.Duration
+
+	driftComp *bool
+
+	jitterStrategyStr *string
+
+	// set by validate
+	jitterStrategy dsp.Strategy
+}
+
+func addSinkFlags(fset *pflag.FlagSet) *sinkFlags {
+	f := &sinkFlags{}
+
+	f.sinkFrame = fset.Duration("sink-frame", 40*time.Millisecond, "sink frame size")
+	f.jitterBuf = fset.Duration("jitter-buf", 120*time.Millisecond, "jitter buffer size")
+	f.pulseBuf = fset.Duration("pulse-buf", 20*time.Millisecond, "pulseaudio buffer size")
+	f.alsaBuf = fset.Duration("alsa-buf", 60*time.Millisecond, "alsa buffer size")
+	f.alsaPeriod = fset.Duration("alsa-period", 20*time.Millisecond, "alsa period size")
+
+	f.maxDrift = fset.Duration("max-drift", 30*time.Millisecond,
+		"maximum jitter buffer drift")
+
+	f.jitterAdaptive = fset.Bool("jitter-adaptive", false,
+		"adapt jitter buffer size to measured network jitter, starting from --jitter-buf")
+	f.jitterMin = fset.Duration("jitter-min", 100*time.Millisecond,
+		"minimum jitter buffer size in adaptive mode")
+	f.jitterMax = fset.Duration("jitter-max", 500*time.Millisecond,
+		"maximum jitter buffer size in adaptive mode")
+
+	f.driftComp = fset.Bool("drift-comp", true,
+		"compensate clock drift by adaptive resampling in jitter buffer")
+
+	f.jitterStrategyStr = fset.String("jitter-strategy", "reset",
+		"jitter buffer underrun and overrun recovery: reset|stretch")
+
+	return f
+}
+
+// if there is no sink, jitter buffer options should not be given;
+// pulseaudio and alsa options are used by source as well
+func (f *sinkFlags) validate(fset *pflag.FlagSet, hasSink bool) error {
+	if !hasSink {
+		for _, name := range []string{
+			"sink-frame", "jitter-buf", "max-drift",
+			"jitter-adaptive", "jitter-strategy", "drift-comp",
+		} {
+			if fset.Changed(name) {
+				return errors.New("--" + name + " is only meaningful when --sink is given")
+			}
+		}
+	}
+
+	if (fset.Changed("jitter-min") || fset.Changed("jitter-max")) && !*f.jitterAdaptive {
+		return errors.New("--jitter-min and --jitter-max are only meaningful" +
+			" when --jitter-adaptive is given")
+	}
+
+	if *f.jitterAdaptive && (*f.jitterBuf < *f.jitterMin || *f.jitterBuf > *f.jitterMax) {
+		return errors.New("--jitter-buf should be between --jitter-min and --jitter-max")
+	}
+
+	// smaller buffer is reset before every read
+	if *f.jitterAdaptive && *f.jitterMin < *f.sinkFrame+*f.maxDrift {
+		return errors.New("--jitter-min should not be less than --sink-frame plus --max-drift")
+	}
+
+	strategy, err := parseJitterStrategy(*f.jitterStrategyStr)
+	if err != nil {
+		return errors.New("invalid --jitter-strategy: " + err.Error())
+	}
+	f.jitterStrategy = strategy
+
+	if *f.alsaPeriod > *f.alsaBuf {
+		return errors.New("--alsa-period should not be greater than --alsa-buf")
+	}
+
+	return nil
+}
+
+// caller should set clock and concealment
+func (f *sinkFlags) jitterBufParams(rate, channels uint, debug bool) dsp.JitterBufParams {
+	return dsp.JitterBufParams{
+		Rate:              int(rate),
+		Channels:          int(channels),
+		ClockRate:         opusClockRate,
+		FrameLength:       *f.sinkFrame,
+		BufferLength:      *f.jitterBuf,
+		MaxDrift:          *f.maxDrift,
+		Adaptive:          *f.jitterAdaptive,
+		MinBufferLength:   *f.jitterMin,
+		MaxBufferLength:   *f.jitterMax,
+		DriftCompensation: *f.driftComp,
+		Strategy:          f.jitterStrategy,
+		Debug:             debug,
+	}
+}
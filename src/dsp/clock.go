@@ -0,0 +1,16 @@
+package dsp
+
+import (
+	"time"
+)
+
+// source of packet arrival times, may be replaced e.g. for replaying captures
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
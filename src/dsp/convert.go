@@ -7,3 +7,35 @@ import (
 func durationToSamples(d time.Duration, rate int) int {
 	return rate * int(d/time.Millisecond) / 1000
 }
+
+func samplesToDuration(n int, rate int) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(rate)
+}
+
+// converts interleaved samples between mono and stereo
+func ConvertChannels(in []int16, inChannels, outChannels int) []int16 {
+	if inChannels == outChannels {
+		return in
+	}
+
+	numFrames := len(in) / inChannels
+	out := make([]int16, numFrames*outChannels)
+
+	for n := 0; n < numFrames; n++ {
+		frame := in[n*inChannels : (n+1)*inChannels]
+
+		if outChannels == 1 {
+			sum := 0
+			for _, v := range frame {
+				sum += int(v)
+			}
+			out[n] = int16(sum / inChannels)
+		} else {
+			for ch := 0; ch < outChannels; ch++ {
+				out[n*outChannels+ch] = frame[ch%inChannels]
+			}
+		}
+	}
+
+	return out
+}
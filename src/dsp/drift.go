@@ -0,0 +1,101 @@
+package dsp
+
+import (
+	"time"
+)
+
+const (
+	// maximum deviation of resampling ratio from 1, small enough to be inaudible
+	maxDriftCorrection = 0.002
+
+	// fill level is averaged over this window to filter out network jitter
+	driftAvgWindow = time.Second
+
+	// proportional and integral gains of the controller
+	driftGainP = 1.0
+	driftGainI = 0.05
+)
+
+// estimates clock drift between sender and receiver from jitter buffer fill level,
+// and computes resampling ratio that keeps fill level near the target
+type DriftEstimator struct {
+	target    float64
+	deviation float64
+
+	// seconds between updates
+	period float64
+	alpha  float64
+
+	avg      float64
+	integral float64
+	started  bool
+
+	ratio float64
+}
+
+// target and deviation are in samples, update period is the frame length
+func NewDriftEstimator(target, deviation int, period time.Duration) *DriftEstimator {
+	if deviation <= 0 {
+		deviation = 1
+	}
+
+	alpha := float64(period) / float64(driftAvgWindow)
+	if alpha > 1 {
+		alpha = 1
+	}
+
+	return &DriftEstimator{
+		target:    float64(target),
+		deviation: float64(deviation),
+		period:    period.Seconds(),
+		alpha:     alpha,
+		ratio:     1,
+	}
+}
+
+// changes target fill level, e.g. when jitter buffer size is adapted
+func (d *DriftEstimator) SetTarget(target int) {
+	d.target = float64(target)
+}
+
+// returns current resampling ratio, > 1 means that buffer should be drained faster
+func (d *DriftEstimator) Ratio() float64 {
+	return d.ratio
+}
+
+// should be called once per read frame with current fill level in samples
+func (d *DriftEstimator) Update(size int) float64 {
+	if !d.started {
+		d.avg = float64(size)
+		d.started = true
+	} else {
+		d.avg += d.alpha * (float64(size) - d.avg)
+	}
+
+	// normalized error, -1 and 1 mean that buffer is going to be reset
+	e := (d.avg - d.target) / d.deviation
+
+	// integral part compensates constant drift without steady error
+	d.integral = clampUnit(d.integral + driftGainI*e*d.period)
+
+	d.ratio = 1 + maxDriftCorrection*clampUnit(driftGainP*e+d.integral)
+
+	return d.ratio
+}
+
+// forgets averaged fill level, e.g. after buffer reset;
+// integral part is kept since clock drift doesn't change
+func (d *DriftEstimator) Reset() {
+	d.avg = 0
+	d.started = false
+}
+
+func clampUnit(v float64) float64 {
+	if v < -1 {
+		return -1
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
@@ -0,0 +1,98 @@
+package dsp
+
+import (
+	"math"
+	"time"
+)
+
+// length of ramps applied around discontinuities, short enough
+// to be unnoticeable but long enough to avoid clicks
+const RampLength = 5 * time.Millisecond
+
+// returns number of interleaved samples in a ramp
+func RampSamples(rate, channels int) int {
+	return durationToSamples(RampLength, rate) * channels
+}
+
+// gain at given position of a ramp from 0 to 1, raised cosine
+func rampGain(n, length int) float64 {
+	return 0.5 - 0.5*math.Cos(math.Pi*(float64(n)+0.5)/float64(length))
+}
+
+// ramps interleaved samples up from silence, in place
+func FadeIn(buf []int16, channels int) {
+	numFrames := len(buf) / channels
+	for n := 0; n < numFrames; n++ {
+		g := rampGain(n, numFrames)
+		for ch := 0; ch < channels; ch++ {
+			buf[n*channels+ch] = int16(float64(buf[n*channels+ch]) * g)
+		}
+	}
+}
+
+// ramps interleaved samples down to silence, in place
+func FadeOut(buf []int16, channels int) {
+	numFrames := len(buf) / channels
+	for n := 0; n < numFrames; n++ {
+		g := rampGain(numFrames-1-n, numFrames)
+		for ch := 0; ch < channels; ch++ {
+			buf[n*channels+ch] = int16(float64(buf[n*channels+ch]) * g)
+		}
+	}
+}
+
+// mixes two overlapping ranges of the same length, fading from a to b,
+// result is written to b
+func Crossfade(a, b []int16, channels int) {
+	numFrames := len(b) / channels
+	for n := 0; n < numFrames; n++ {
+		g := rampGain(n, numFrames)
+		for ch := 0; ch < channels; ch++ {
+			i := n*channels + ch
+			b[i] = clampInt16(float64(a[i])*(1-g) + float64(b[i])*g)
+		}
+	}
+}
+
+// smooths discontinuities in a stream of frames: audio before discontinuity is
+// faded out and audio after it is faded in; to make this possible, output is
+// delayed by ramp length
+type Fader struct {
+	channels int
+	ramp     int
+
+	// end of previous frame, not returned yet
+	tail []int16
+}
+
+func NewFader(rate, channels int) *Fader {
+	ramp := RampSamples(rate, channels)
+
+	return &Fader{
+		channels: channels,
+		ramp:     ramp,
+		tail:     make([]int16, ramp),
+	}
+}
+
+// returns frame of the same size, discont should be set if the frame
+// doesn't continue the previous one, e.g. samples were skipped or zeros inserted
+func (f *Fader) Process(frame []int16, discont bool) []int16 {
+	if len(frame) < 2*f.ramp {
+		return frame
+	}
+
+	out := make([]int16, len(frame))
+	copy(out, f.tail)
+
+	head := out[f.ramp:]
+	copy(head, frame[:len(frame)-f.ramp])
+	copy(f.tail, frame[len(frame)-f.ramp:])
+
+	if discont {
+		FadeOut(out[:f.ramp], f.channels)
+		FadeIn(head[:f.ramp], f.channels)
+	}
+
+	return out
+}
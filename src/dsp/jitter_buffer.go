@@ -3,11 +3,26 @@ package dsp
 import (
 	"errors"
 	"fmt"
-	"os"
 	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
+
+	"github.com/gavv/webrtc-cli/src/log"
+)
+
+var jitbufLog = log.New("jitbuf")
+
+type Strategy int
+
+const (
+	// reset buffer when its size goes out of bounds,
+	// i.e. insert zeros on underrun and drop samples on overrun
+	StrategyReset Strategy = iota
+
+	// time-stretch audio when buffer size goes far from target,
+	// reset buffer only when it becomes empty or hugely overrun
+	StrategyStretch
 )
 
 type JitterBufParams struct {
@@ -16,9 +31,52 @@ type JitterBufParams struct {
 	FrameLength  time.Duration
 	BufferLength time.Duration
 	MaxDrift     time.Duration
-	Debug        bool
+	// move target size between min and max length depending on measured jitter,
+	// buffer length is used as initial target
+	Adaptive        bool
+	MinBufferLength time.Duration
+	MaxBufferLength time.Duration
+	// compensate clock drift by adaptive resampling
+	DriftCompensation bool
+	// how to recover from underrun and overrun
+	Strategy Strategy
+	// clock rate of timestamps passed to WriteWithTimestamp, e.g. 48000 for
+	// opus RTP timestamps regardless of sample rate; sample rate by default
+	ClockRate int
+	// used for packet arrival times, system clock by default
+	Clock Clock
+	// optional, called when buffer is empty to produce samples instead of zeros,
+	// e.g. using PLC; may return fewer samples than requested
+	Conceal func(numSamples int) []int16
+	// optional, same as Conceal, but samples don't replace missing ones; used to
+	// continue concealment while buffer is refilled after stream resumed
+	Extrapolate func(numSamples int) []int16
+	Debug       bool
 }
 
+type JitterBufStats struct {
+	// current and target buffer size
+	Length       time.Duration
+	TargetLength time.Duration
+	// estimated interarrival jitter
+	Jitter time.Duration
+	// resampling ratio, 1 if drift compensation is disabled
+	DriftRatio float64
+	// totals since start
+	Resets           int
+	Stretches        int
+	DroppedSamples   int
+	InsertedZeros    int
+	ConcealedSamples int
+}
+
+// target size is a multiple of jitter estimate
+const jitterTargetFactor = 4
+
+// with stretch strategy, this number of frames is played as one more frame,
+// or this number of frames plus one is played as one less frame
+const stretchFrames = 4
+
 type JitterBuf struct {
 	mu sync.Mutex
 
@@ -31,18 +89,60 @@ type JitterBuf struct {
 	buf []int16
 
 	frameSize  int
+	packetSize int
 	targetSize int
 	minSize    int
 	maxSize    int
-
-	logDrop  *rate.Limiter
-	logZero  *rate.Limiter
-	logReset *rate.Limiter
-	logSize  *rate.Limiter
+	driftSize  int
+
+	rate     int
+	channels int
+
+	// set if adaptive mode is enabled;
+	// target size is gradually moved towards wanted size
+	adaptive      bool
+	wantSize      int
+	minTargetSize int
+	maxTargetSize int
+
+	clock     Clock
+	estimator *JitterEstimator
+
+	// set if drift compensation is enabled
+	drift     *DriftEstimator
+	resampler *SincResampler
+
+	// set if stretch strategy is used
+	stretcher *TimeStretcher
+
+	// samples produced by resampler or stretcher, but not read yet
+	pending []int16
+
+	// smooths starts, resets, and underruns;
+	// discontinuity before current frame and before next frame
+	fader       *Fader
+	discont     bool
+	discontNext bool
+
+	// set if concealment is enabled
+	conceal     func(int) []int16
+	extrapolate func(int) []int16
+	concealed   bool
+	// last frame was concealed while refilling buffer
+	refillConcealed bool
+
+	logDrop    *rate.Limiter
+	logZero    *rate.Limiter
+	logReset   *rate.Limiter
+	logSize    *rate.Limiter
+	logTarget  *rate.Limiter
+	logStretch *rate.Limiter
 
 	nDropped int
 	nZeros   int
 	nResets  int
+
+	stats JitterBufStats
 }
 
 func NewJitterBuf(p JitterBufParams) (*JitterBuf, error) {
@@ -51,21 +151,56 @@ func NewJitterBuf(p JitterBufParams) (*JitterBuf, error) {
 	bufferSize := durationToSamples(p.BufferLength, p.Rate) * p.Channels
 	bufferDrift := durationToSamples(p.MaxDrift, p.Rate) * p.Channels
 
+	clockRate := p.ClockRate
+	if clockRate == 0 {
+		clockRate = p.Rate
+	}
+
 	freq := 0.01
-	if p.Debug {
+	if p.Debug || jitbufLog.Enabled(log.LevelDebug) {
 		freq = 0.5
 	}
 
 	j := &JitterBuf{
-		starting:   true,
-		frameSize:  frameSize,
-		targetSize: bufferSize,
-		minSize:    bufferSize - bufferDrift,
-		maxSize:    bufferSize + bufferDrift,
-		logDrop:    rate.NewLimiter(rate.Limit(freq), 1),
-		logZero:    rate.NewLimiter(rate.Limit(freq), 1),
-		logReset:   rate.NewLimiter(rate.Limit(freq), 1),
-		logSize:    rate.NewLimiter(rate.Limit(freq), 1),
+		starting:    true,
+		frameSize:   frameSize,
+		targetSize:  bufferSize,
+		wantSize:    bufferSize,
+		minSize:     bufferSize - bufferDrift,
+		maxSize:     bufferSize + bufferDrift,
+		driftSize:   bufferDrift,
+		rate:        p.Rate,
+		channels:    p.Channels,
+		clock:       p.Clock,
+		conceal:     p.Conceal,
+		extrapolate: p.Extrapolate,
+		estimator:   NewJitterEstimator(clockRate),
+		fader:       NewFader(p.Rate, p.Channels),
+		logDrop:     rate.NewLimiter(rate.Limit(freq), 1),
+		logZero:     rate.NewLimiter(rate.Limit(freq), 1),
+		logReset:    rate.NewLimiter(rate.Limit(freq), 1),
+		logSize:     rate.NewLimiter(rate.Limit(freq), 1),
+		logTarget:   rate.NewLimiter(rate.Limit(freq), 1),
+		logStretch:  rate.NewLimiter(rate.Limit(freq), 1),
+	}
+
+	if j.clock == nil {
+		j.clock = systemClock{}
+	}
+
+	if p.Adaptive {
+		j.adaptive = true
+		j.minTargetSize = durationToSamples(p.MinBufferLength, p.Rate) * p.Channels
+		j.maxTargetSize = durationToSamples(p.MaxBufferLength, p.Rate) * p.Channels
+	}
+
+	if p.DriftCompensation {
+		j.drift = NewDriftEstimator(bufferSize, bufferDrift, p.FrameLength)
+		j.resampler = NewSincResampler(p.Channels)
+	}
+
+	if p.Strategy == StrategyStretch {
+		j.stretcher = NewTimeStretcher(p.Rate, p.Channels)
 	}
 
 	return j, nil
@@ -87,6 +222,46 @@ func (j *JitterBuf) Write(buf []int16) {
 	j.validateBuffer()
 }
 
+// same as Write, but also uses RTP timestamp of the packet and its arrival time
+// to estimate network jitter; clock rate of timestamp is set by ClockRate
+func (j *JitterBuf) WriteWithTimestamp(buf []int16, timestamp uint32) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.estimator.Update(timestamp, j.clock.Now())
+
+	if j.adaptive && j.estimator.Settled() {
+		j.adaptTarget()
+	}
+
+	j.writeFrame(buf)
+
+	j.validateBuffer()
+}
+
+func (j *JitterBuf) Stats() JitterBufStats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	stats := j.stats
+
+	bsize := j.fillLevel()
+	if bsize < 0 {
+		bsize = 0
+	}
+
+	stats.Length = samplesToDuration(bsize/j.channels, j.rate)
+	stats.TargetLength = samplesToDuration(j.targetSize/j.channels, j.rate)
+	stats.Jitter = j.estimator.Jitter()
+
+	stats.DriftRatio = 1
+	if j.drift != nil {
+		stats.DriftRatio = j.drift.Ratio()
+	}
+
+	return stats
+}
+
 func (j *JitterBuf) writeFrame(buf []int16) {
 	if j.closed {
 		return
@@ -94,6 +269,8 @@ func (j *JitterBuf) writeFrame(buf []int16) {
 
 	bufLen := len(buf)
 
+	j.packetSize = bufLen
+
 	bsize := j.bufferSize()
 	if bsize < 0 {
 		shiftLen := -bsize
@@ -103,9 +280,11 @@ func (j *JitterBuf) writeFrame(buf []int16) {
 
 		buf = buf[shiftLen:]
 
+		j.stats.DroppedSamples += shiftLen
 		j.nDropped += shiftLen
 		if j.logDrop.Allow() {
-			fmt.Fprintf(os.Stderr, "Dropped %d outdated samples\n", j.nDropped)
+			jitbufLog.Warn("samples_dropped", log.Fields{"samples": j.nDropped},
+				"Dropped %d outdated samples", j.nDropped)
 			j.nDropped = 0
 		}
 	}
@@ -121,7 +300,16 @@ func (j *JitterBuf) Read() ([]int16, error) {
 	j.mu.Lock()
 	defer j.mu.Unlock()
 
-	return j.readFrame()
+	frame, err := j.readFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	frame = j.fader.Process(frame, j.discont)
+
+	j.discont, j.discontNext = j.discontNext, false
+
+	return frame, nil
 }
 
 func (j *JitterBuf) readFrame() ([]int16, error) {
@@ -130,12 +318,15 @@ func (j *JitterBuf) readFrame() ([]int16, error) {
 	}
 
 	if !j.starting {
-		bsize := j.bufferSize()
+		bsize := j.fillLevel()
+		minSize, maxSize := j.resetBounds()
 
-		if bsize <= j.minSize || bsize >= j.maxSize {
+		if (bsize <= minSize && !j.deferReset()) || bsize >= maxSize {
 			j.resetBuffer()
 			j.validateBuffer()
 			j.starting = true
+		} else if j.adaptive {
+			j.moveTarget()
 		}
 	}
 
@@ -143,61 +334,361 @@ func (j *JitterBuf) readFrame() ([]int16, error) {
 		bsize := j.bufferSize()
 
 		if bsize < j.targetSize {
+			// continue concealment until buffer is refilled
+			if frame := j.concealFrame(); frame != nil {
+				j.refillConcealed = true
+				return frame, nil
+			}
+
+			j.refillConcealed = false
+			j.discont = true
+			j.discontNext = true
 			return make([]int16, j.frameSize), nil
 		}
 
-		if bsize > j.targetSize+j.frameSize {
-			j.trimBuffer(j.targetSize + j.frameSize)
+		// start exactly from target size, since reset bounds are checked
+		// against it before every read
+		if bsize > j.targetSize {
+			j.trimBuffer(j.targetSize)
 		}
+		if j.refillConcealed && j.crossfadeConcealed() {
+			j.discont = false
+		}
+
+		j.refillConcealed = false
 	}
 
 	j.validateBuffer()
 
 	j.starting = false
 
-	frame := j.shiftFrame()
+	if j.drift == nil && j.stretcher == nil {
+		return j.shiftFrame(), nil
+	}
 
-	return frame, nil
+	return j.shiftPending(), nil
+}
+
+// with concealment, underrun is handled when buffer becomes empty,
+// and buffer is reset only after that to refill it
+func (j *JitterBuf) deferReset() bool {
+	return j.conceal != nil && !j.concealed
+}
+
+// produces frame using concealment, advancing both read and write positions
+// by frame size, so that buffer size is kept; if fewer samples were concealed,
+// the rest of frame is zero-padded
+func (j *JitterBuf) concealFrame() []int16 {
+	var samples []int16
+	if len(j.buf) == 0 {
+		if j.conceal != nil {
+			samples = j.conceal(j.frameSize)
+		}
+	} else if j.refillConcealed && j.extrapolate != nil {
+		// buffered samples will be played after concealed ones
+		samples = j.extrapolate(j.frameSize)
+	}
+	if len(samples) == 0 {
+		return nil
+	}
+
+	j.concealed = true
+	j.stats.ConcealedSamples += len(samples)
+
+	j.wpos += uint64(j.frameSize)
+	j.rpos += uint64(j.frameSize)
+
+	frame := make([]int16, j.frameSize)
+	copy(frame, samples)
+
+	return frame
+}
+
+// smooths transition from concealment to refilled buffer,
+// reports whether it was done
+func (j *JitterBuf) crossfadeConcealed() bool {
+	if j.extrapolate == nil || len(j.buf) < j.fader.ramp {
+		return false
+	}
+
+	tail := j.extrapolate(j.fader.ramp)
+	if len(tail) != j.fader.ramp {
+		return false
+	}
+
+	Crossfade(tail, j.buf[:j.fader.ramp], j.channels)
+
+	return true
+}
+
+// stretching handles moderate deviations by itself
+func (j *JitterBuf) resetBounds() (int, int) {
+	if j.stretcher != nil {
+		return 0, j.maxSize + j.targetSize
+	}
+	return j.minSize, j.maxSize
+}
+
+// instead of exact frame, consumes more or less input samples, slightly
+// when compensating drift and noticeably when stretching, so that buffer
+// size converges to target size
+func (j *JitterBuf) shiftPending() []int16 {
+	if j.drift != nil {
+		ratio := j.drift.Update(j.fillLevel())
+		j.resampler.SetRatio(ratio)
+	}
+
+	for len(j.pending) < j.frameSize {
+		var block []int16
+		if j.stretcher != nil {
+			block = j.shiftStretched()
+		} else {
+			block = j.shiftFrame()
+		}
+
+		if j.resampler != nil {
+			block = j.resampler.Process(block)
+		}
+
+		j.pending = append(j.pending, block...)
+	}
+
+	frame := make([]int16, j.frameSize)
+	copy(frame, j.pending)
+	j.pending = j.pending[j.frameSize:]
+
+	return frame
+}
+
+// expands a few frames when buffer is too small and compresses a few frames
+// when it's too large, otherwise just shifts one frame
+func (j *JitterBuf) shiftStretched() []int16 {
+	bsize := j.fillLevel()
+	threshold := j.driftSize / 2
+
+	inFrames, outFrames := 1, 1
+
+	switch {
+	case bsize < j.targetSize-threshold:
+		inFrames = bsize / j.frameSize
+		if inFrames > stretchFrames {
+			inFrames = stretchFrames
+		}
+		outFrames = inFrames + 1
+
+	case bsize > j.targetSize+threshold && bsize >= (stretchFrames+1)*j.frameSize:
+		inFrames = stretchFrames + 1
+		outFrames = stretchFrames
+	}
+
+	if inFrames == 0 || inFrames == outFrames {
+		return j.shiftFrame()
+	}
+
+	in := j.shiftSamples(inFrames * j.frameSize)
+	out := j.stretcher.Stretch(in, outFrames*j.frameSize)
+
+	j.stats.Stretches++
+	if j.logStretch.Allow() {
+		jitbufLog.Debug("time_stretched", log.Fields{
+			"in_samples":  len(in),
+			"out_samples": len(out),
+			"size":        j.fillLevel(),
+			"target_size": j.targetSize,
+		}, "Time-stretched %d samples to %d samples, buffer size is %d, target size is %d",
+			len(in), len(out), j.fillLevel(), j.targetSize)
+	}
+
+	return out
 }
 
 func (j *JitterBuf) shiftFrame() []int16 {
 	if j.logSize.Allow() {
-		fmt.Fprintf(os.Stderr, "Jitter buffer size is %d, target size is %d\n",
-			j.bufferSize(), j.targetSize)
+		fields := log.Fields{"size": j.fillLevel(), "target_size": j.targetSize}
+		if j.drift != nil {
+			fields["ratio"] = j.drift.Ratio()
+			jitbufLog.Debug("buffer_size", fields,
+				"Jitter buffer size is %d, target size is %d, resampling ratio is %.5f",
+				j.fillLevel(), j.targetSize, j.drift.Ratio())
+		} else {
+			jitbufLog.Debug("buffer_size", fields,
+				"Jitter buffer size is %d, target size is %d",
+				j.fillLevel(), j.targetSize)
+		}
 	}
 
-	j.rpos += uint64(j.frameSize)
+	return j.shiftSamples(j.frameSize)
+}
+
+func (j *JitterBuf) shiftSamples(n int) []int16 {
+	j.rpos += uint64(n)
+
+	if len(j.buf) >= n {
+		frame := j.buf[:n]
+		j.buf = j.buf[n:]
+		return frame
+	}
+
+	// conceal missing samples instead of padding them with zeros
+	if j.conceal != nil {
+		if samples := j.conceal(n - len(j.buf)); len(samples) != 0 {
+			j.concealed = true
+			j.stats.ConcealedSamples += len(samples)
 
-	if len(j.buf) >= j.frameSize {
-		frame := j.buf[:j.frameSize]
-		j.buf = j.buf[j.frameSize:]
+			j.wpos += uint64(len(samples))
+			j.buf = append(j.buf, samples...)
+
+			if len(j.buf) >= n {
+				frame := j.buf[:n]
+				j.buf = j.buf[n:]
+				return frame
+			}
+		}
+	}
+
+	// stretch what we have instead of padding it with zeros,
+	// unless it's too short
+	if j.stretcher != nil && len(j.buf) >= n/2 {
+		frame := j.stretcher.Stretch(j.buf, n)
+		j.buf = nil
+		j.stats.Stretches++
 		return frame
 	}
 
-	j.nZeros += j.frameSize - len(j.buf)
+	// avoid click where audio ends, and fade in where it resumes
+	if len(j.buf) == 0 {
+		j.discont = true
+	} else {
+		tail := len(j.buf)
+		if tail > j.fader.ramp {
+			tail = j.fader.ramp
+		}
+		FadeOut(j.buf[len(j.buf)-tail:], j.channels)
+	}
+	j.discontNext = true
+
+	j.stats.InsertedZeros += n - len(j.buf)
+	j.nZeros += n - len(j.buf)
 	if j.logZero.Allow() {
-		fmt.Fprintf(os.Stderr,
-			"Inserted zeros instead of %d delayed samples\n", j.nZeros)
+		jitbufLog.Warn("zeros_inserted", log.Fields{"samples": j.nZeros},
+			"Inserted zeros instead of %d delayed samples", j.nZeros)
 		j.nZeros = 0
 	}
 
-	frame := make([]int16, j.frameSize)
+	frame := make([]int16, n)
 	copy(frame, j.buf)
 	j.buf = nil
 	return frame
 }
 
 func (j *JitterBuf) resetBuffer() {
+	j.concealed = false
+	j.stats.Resets++
 	j.nResets++
 
 	if j.logReset.Allow() {
-		fmt.Fprintf(os.Stderr, "Resetting buffer %d times, buffer size is %d\n",
-			j.nResets, j.bufferSize())
+		jitbufLog.Warn("buffer_reset", log.Fields{"resets": j.nResets, "size": j.fillLevel()},
+			"Resetting buffer %d times, buffer size is %d", j.nResets, j.fillLevel())
 		j.nResets = 0
 	}
 
 	j.rpos = 0
 	j.wpos = uint64(len(j.buf))
+
+	// buffer is refilled anyway, so target can be changed at once
+	j.setTarget(j.wantSize)
+	j.minSize = j.targetSize - j.driftSize
+	j.maxSize = j.targetSize + j.driftSize
+
+	if j.drift != nil {
+		j.drift.Reset()
+		j.resampler.Reset()
+	}
+
+	j.pending = nil
+}
+
+// chooses wanted size for current jitter, and changes it only when
+// it differs noticeably in either direction, to avoid flapping
+func (j *JitterBuf) adaptTarget() {
+	jitterSize := durationToSamples(j.estimator.Jitter(), j.rate) * j.channels
+
+	wantSize := jitterTargetFactor * jitterSize
+	if wantSize < j.minTargetSize {
+		wantSize = j.minTargetSize
+	}
+	if wantSize > j.maxTargetSize {
+		wantSize = j.maxTargetSize
+	}
+
+	// even without jitter, buffer should hold one read frame after drifting
+	// to lower bound, and packets arrive in whole packets
+	if minSize := j.frameSize + j.driftSize + j.packetSize; wantSize < minSize {
+		wantSize = minSize
+	}
+
+	hysteresis := j.driftSize / 2
+	if wantSize > j.wantSize-hysteresis && wantSize < j.wantSize+hysteresis {
+		return
+	}
+
+	j.wantSize = wantSize
+
+	if j.logTarget.Allow() {
+		jitbufLog.Info("target_changed", log.Fields{
+			"target_size": j.wantSize,
+			"jitter_ms":   log.Millis(j.estimator.Jitter()),
+		}, "Jitter buffer target size changed to %d, jitter is %s",
+			j.wantSize, j.estimator.Jitter())
+	}
+}
+
+// moves target towards wanted size by at most half of max drift per step,
+// and makes next step only after buffer size followed the previous one,
+// e.g. pulled by drift compensation or stretching; until then, reset bounds
+// cover both old and new target, so that moving target doesn't cause reset
+func (j *JitterBuf) moveTarget() {
+	bsize := j.fillLevel()
+	if bsize <= j.targetSize-j.driftSize || bsize >= j.targetSize+j.driftSize {
+		return
+	}
+
+	step := j.driftSize / 2 / j.channels * j.channels
+	if step == 0 {
+		step = j.channels
+	}
+
+	oldSize := j.targetSize
+
+	newSize := j.wantSize
+	if newSize < oldSize-step {
+		newSize = oldSize - step
+	}
+	if newSize > oldSize+step {
+		newSize = oldSize + step
+	}
+
+	j.setTarget(newSize)
+
+	if newSize < oldSize {
+		j.minSize = newSize - j.driftSize
+		j.maxSize = oldSize + j.driftSize
+	} else {
+		j.minSize = oldSize - j.driftSize
+		j.maxSize = newSize + j.driftSize
+	}
+}
+
+func (j *JitterBuf) setTarget(size int) {
+	if size == j.targetSize {
+		return
+	}
+
+	j.targetSize = size
+
+	if j.drift != nil {
+		j.drift.SetTarget(size)
+	}
 }
 
 func (j *JitterBuf) trimBuffer(maxSize int) {
@@ -210,6 +701,8 @@ func (j *JitterBuf) trimBuffer(maxSize int) {
 	}
 
 	j.rpos += uint64(shiftLen)
+
+	j.discont = true
 }
 
 func (j *JitterBuf) validateBuffer() {
@@ -228,6 +721,13 @@ func (j *JitterBuf) validateBuffer() {
 	}
 }
 
+// samples available for playback, including those produced by resampler or
+// stretcher but not read yet; all checks of buffer size against target and
+// bounds use it, so that they agree with drift compensation
+func (j *JitterBuf) fillLevel() int {
+	return j.bufferSize() + len(j.pending)
+}
+
 func (j *JitterBuf) bufferSize() int {
 	return int(int64(j.wpos - j.rpos))
 }
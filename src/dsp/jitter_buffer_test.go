@@ -0,0 +1,111 @@
+package dsp
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// simulates sender writing packets with skewed clock and random network jitter,
+// and receiver reading frames with its own clock, using default options
+func runJitterBuf(
+	t *testing.T, p JitterBufParams, skewPPM float64, jitter, duration time.Duration,
+) JitterBufStats {
+	const (
+		rate        = 48000
+		channels    = 2
+		packetLen   = 20 * time.Millisecond
+		packetSize  = 960
+		readerPhase = 7 * time.Millisecond
+	)
+
+	clock := &testClock{now: time.Unix(1000, 0)}
+
+	p.Rate = rate
+	p.Channels = channels
+	p.ClockRate = rate
+	p.Clock = clock
+
+	j, err := NewJitterBuf(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+
+	start := clock.now
+	sendPeriod := time.Duration(float64(packetLen) * (1 + skewPPM/1e6))
+	packet := make([]int16, packetSize*channels)
+
+	numPackets := 0
+	nextSend := start
+	nextRead := start.Add(readerPhase)
+
+	for nextRead.Before(start.Add(duration)) {
+		arrival := nextSend.Add(time.Duration(rnd.Float64() * float64(jitter)))
+
+		if arrival.Before(nextRead) {
+			clock.now = arrival
+			j.WriteWithTimestamp(packet, uint32(numPackets*packetSize))
+
+			numPackets++
+			nextSend = start.Add(time.Duration(numPackets) * sendPeriod)
+		} else {
+			clock.now = nextRead
+			if _, err := j.Read(); err != nil {
+				t.Fatal(err)
+			}
+
+			nextRead = nextRead.Add(p.FrameLength)
+		}
+	}
+
+	return j.Stats()
+}
+
+func defaultJitterBufParams() JitterBufParams {
+	return JitterBufParams{
+		FrameLength:       40 * time.Millisecond,
+		BufferLength:      120 * time.Millisecond,
+		MaxDrift:          30 * time.Millisecond,
+		DriftCompensation: true,
+	}
+}
+
+func TestJitterBufClockSkew(t *testing.T) {
+	for _, skew := range []float64{-500, -100, 100, 500} {
+		stats := runJitterBuf(t, defaultJitterBufParams(),
+			skew, time.Millisecond, time.Minute)
+
+		if stats.Resets != 0 || stats.InsertedZeros != 0 || stats.DroppedSamples != 0 {
+			t.Errorf("skew %vppm: got %d resets, %d zeros, %d dropped samples",
+				skew, stats.Resets, stats.InsertedZeros, stats.DroppedSamples)
+		}
+	}
+}
+
+func TestJitterBufAdaptiveMinSize(t *testing.T) {
+	p := defaultJitterBufParams()
+	p.Adaptive = true
+	p.MinBufferLength = p.FrameLength + p.MaxDrift
+	p.MaxBufferLength = 500 * time.Millisecond
+
+	stats := runJitterBuf(t, p, 500, 2*time.Millisecond, time.Minute)
+
+	if stats.Resets != 0 || stats.InsertedZeros != 0 || stats.DroppedSamples != 0 {
+		t.Errorf("got %d resets, %d zeros, %d dropped samples",
+			stats.Resets, stats.InsertedZeros, stats.DroppedSamples)
+	}
+	if stats.TargetLength < p.MinBufferLength {
+		t.Errorf("target length %s is less than minimum %s",
+			stats.TargetLength, p.MinBufferLength)
+	}
+}
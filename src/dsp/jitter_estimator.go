@@ -0,0 +1,66 @@
+package dsp
+
+import (
+	"math"
+	"time"
+)
+
+// estimate is not reliable until this number of packets is received
+const minJitterPackets = 32
+
+// larger timing differences mean that stream was restarted, not jitter
+const maxTransitDelta = time.Second
+
+// estimates interarrival jitter from packet arrival times and RTP timestamps,
+// as described in RFC 3550, section 6.4.1
+type JitterEstimator struct {
+	clockRate int
+
+	lastArrival   time.Time
+	lastTimestamp uint32
+	started       bool
+	numPackets    int
+
+	// seconds
+	jitter float64
+}
+
+func NewJitterEstimator(clockRate int) *JitterEstimator {
+	return &JitterEstimator{
+		clockRate: clockRate,
+	}
+}
+
+// reports whether enough packets were received
+func (e *JitterEstimator) Settled() bool {
+	return e.numPackets >= minJitterPackets
+}
+
+// returns current jitter estimate
+func (e *JitterEstimator) Jitter() time.Duration {
+	return time.Duration(e.jitter * float64(time.Second))
+}
+
+// should be called for every received packet
+func (e *JitterEstimator) Update(timestamp uint32, arrival time.Time) {
+	if !e.started {
+		e.lastArrival = arrival
+		e.lastTimestamp = timestamp
+		e.started = true
+		return
+	}
+
+	// difference of relative transit times of two packets
+	d := arrival.Sub(e.lastArrival).Seconds() -
+		float64(int32(timestamp-e.lastTimestamp))/float64(e.clockRate)
+
+	e.lastArrival = arrival
+	e.lastTimestamp = timestamp
+
+	if math.Abs(d) > maxTransitDelta.Seconds() {
+		return
+	}
+
+	e.jitter += (math.Abs(d) - e.jitter) / 16
+	e.numPackets++
+}
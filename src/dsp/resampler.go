@@ -0,0 +1,74 @@
+package dsp
+
+// converts sample rate using linear interpolation, keeps state between calls
+type Resampler struct {
+	channels int
+
+	inRate  int64
+	outRate int64
+
+	// position of next output frame, relative to last input frame,
+	// in units of 1/outRate of input frame
+	pos int64
+
+	last    []int16
+	hasLast bool
+}
+
+func NewResampler(inRate, outRate, channels int) *Resampler {
+	return &Resampler{
+		channels: channels,
+		inRate:   int64(inRate),
+		outRate:  int64(outRate),
+		last:     make([]int16, channels),
+	}
+}
+
+func (r *Resampler) Process(in []int16) []int16 {
+	numFrames := len(in) / r.channels
+	if numFrames == 0 {
+		return nil
+	}
+
+	// frame 0 is the last frame of previous call, if any
+	offset := 0
+	if r.hasLast {
+		offset = 1
+	}
+	total := numFrames + offset
+
+	frame := func(i int) []int16 {
+		if i < offset {
+			return r.last
+		}
+		i -= offset
+		return in[i*r.channels : (i+1)*r.channels]
+	}
+
+	end := int64(total-1) * r.outRate
+
+	out := make([]int16, 0, (end/r.inRate+1)*int64(r.channels))
+
+	for ; r.pos <= end; r.pos += r.inRate {
+		i := int(r.pos / r.outRate)
+		frac := float64(r.pos%r.outRate) / float64(r.outRate)
+
+		a := frame(i)
+		b := a
+		if i+1 < total {
+			b = frame(i + 1)
+		}
+
+		for ch := 0; ch < r.channels; ch++ {
+			v := float64(a[ch])*(1-frac) + float64(b[ch])*frac
+			out = append(out, int16(v))
+		}
+	}
+
+	r.pos -= end
+
+	copy(r.last, frame(total-1))
+	r.hasLast = true
+
+	return out
+}
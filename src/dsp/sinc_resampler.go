@@ -0,0 +1,173 @@
+package dsp
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	// filter length is twice this number of input frames; it's long enough
+	// to keep response flat up to 20kHz at 48kHz for any fractional position
+	sincHalfTaps = 32
+
+	// filter is tabulated for this number of fractional positions,
+	// and linearly interpolated between them
+	sincPhases = 256
+
+	// kaiser window parameter, gives about 70dB stopband attenuation
+	sincKaiserBeta = 7
+)
+
+var (
+	sincOnce  sync.Once
+	sincTable [][]float64
+)
+
+// changes playback speed by a ratio close to 1 using windowed-sinc interpolation;
+// unlike linear interpolation, doesn't attenuate high frequencies depending on
+// fractional position, so it can run continuously, e.g. to compensate clock drift;
+// delays audio by sincHalfTaps frames
+type SincResampler struct {
+	channels int
+
+	// input advance per output frame
+	step float64
+
+	// input frames, starting from the oldest one still needed
+	buf []int16
+
+	// position of next output frame in buf, in frames
+	pos float64
+
+	coeffs []float64
+}
+
+func NewSincResampler(channels int) *SincResampler {
+	sincOnce.Do(initSincTable)
+
+	r := &SincResampler{
+		channels: channels,
+		step:     1,
+		coeffs:   make([]float64, 2*sincHalfTaps),
+	}
+
+	r.Reset()
+
+	return r
+}
+
+// ratio > 1 consumes input faster, i.e. produces fewer output samples
+func (r *SincResampler) SetRatio(ratio float64) {
+	r.step = ratio
+}
+
+// drops state, next call starts from scratch
+func (r *SincResampler) Reset() {
+	// history before the first input frame is silence
+	r.buf = make([]int16, sincHalfTaps*r.channels)
+	r.pos = sincHalfTaps
+}
+
+func (r *SincResampler) Process(in []int16) []int16 {
+	r.buf = append(r.buf, in...)
+
+	numFrames := len(r.buf) / r.channels
+
+	out := make([]int16, 0, int(float64(numFrames)/r.step+1)*r.channels)
+	sums := make([]float64, r.channels)
+
+	// filter for output at pos uses input frames [pos-halfTaps+1; pos+halfTaps]
+	for int(r.pos)+sincHalfTaps < numFrames {
+		i := int(r.pos)
+		r.interpolateCoeffs(r.pos - float64(i))
+
+		window := r.buf[(i-sincHalfTaps+1)*r.channels : (i+sincHalfTaps+1)*r.channels]
+
+		for ch := range sums {
+			sums[ch] = 0
+		}
+		for k, c := range r.coeffs {
+			frame := window[k*r.channels : (k+1)*r.channels]
+			for ch, v := range frame {
+				sums[ch] += float64(v) * c
+			}
+		}
+		for _, v := range sums {
+			out = append(out, clampInt16(v))
+		}
+
+		r.pos += r.step
+	}
+
+	// drop frames that won't be used anymore
+	if drop := int(r.pos) - sincHalfTaps + 1; drop > 0 {
+		if drop > numFrames {
+			drop = numFrames
+		}
+		r.buf = append(r.buf[:0], r.buf[drop*r.channels:]...)
+		r.pos -= float64(drop)
+	}
+
+	return out
+}
+
+func (r *SincResampler) interpolateCoeffs(frac float64) {
+	p := frac * sincPhases
+	n := int(p)
+	t := p - float64(n)
+
+	a, b := sincTable[n], sincTable[n+1]
+	for k := range r.coeffs {
+		r.coeffs[k] = a[k] + (b[k]-a[k])*t
+	}
+}
+
+// row n holds filter for fractional position n/sincPhases,
+// tap k is applied to input frame at offset k-sincHalfTaps+1
+func initSincTable() {
+	sincTable = make([][]float64, sincPhases+1)
+
+	for n := range sincTable {
+		frac := float64(n) / sincPhases
+		row := make([]float64, 2*sincHalfTaps)
+
+		sum := 0.0
+		for k := range row {
+			x := float64(k-sincHalfTaps+1) - frac
+			row[k] = sinc(x) * kaiser(x/sincHalfTaps, sincKaiserBeta)
+			sum += row[k]
+		}
+
+		// unity gain at DC
+		for k := range row {
+			row[k] /= sum
+		}
+
+		sincTable[n] = row
+	}
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// kaiser window at x in [-1; 1]
+func kaiser(x, beta float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	return besselI0(beta*math.Sqrt(1-x*x)) / besselI0(beta)
+}
+
+// modified bessel function of the first kind, zero order
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > sum*1e-12; k++ {
+		term *= (x / 2) * (x / 2) / float64(k*k)
+		sum += term
+	}
+	return sum
+}
@@ -0,0 +1,165 @@
+package dsp
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// segment length, should be larger than pitch period
+	wsolaWindow = 20 * time.Millisecond
+
+	// how far from ideal position to search for the most similar segment
+	wsolaTolerance = 5 * time.Millisecond
+)
+
+// changes duration of audio preserving pitch, using WSOLA (waveform similarity
+// overlap-add); first and last samples of output match input, so that stretched
+// block can be placed between unmodified audio without clicks
+type TimeStretcher struct {
+	channels int
+
+	window    int
+	hop       int
+	tolerance int
+
+	weights []float64
+}
+
+func NewTimeStretcher(rate, channels int) *TimeStretcher {
+	window := durationToSamples(wsolaWindow, rate)
+
+	t := &TimeStretcher{
+		channels:  channels,
+		window:    window,
+		hop:       window / 2,
+		tolerance: durationToSamples(wsolaTolerance, rate),
+		weights:   make([]float64, window),
+	}
+
+	// hann window, without zeros at the edges
+	for n := range t.weights {
+		t.weights[n] = 0.5 - 0.5*math.Cos(2*math.Pi*(float64(n)+0.5)/float64(window))
+	}
+
+	return t
+}
+
+// returns input stretched or compressed to given number of samples
+func (t *TimeStretcher) Stretch(in []int16, outLen int) []int16 {
+	inFrames := len(in) / t.channels
+	outFrames := outLen / t.channels
+
+	if inFrames < t.window || outFrames < t.window {
+		return t.resample(in, outLen)
+	}
+
+	mono := make([]float64, inFrames)
+	for n := range mono {
+		for ch := 0; ch < t.channels; ch++ {
+			mono[n] += float64(in[n*t.channels+ch])
+		}
+	}
+
+	// segments are evenly distributed, so that first and last are exactly
+	// at the edges of input and output
+	numSegments := (outFrames-t.window+t.hop-1)/t.hop + 1
+	if numSegments < 2 {
+		numSegments = 2
+	}
+
+	sum := make([]float64, outFrames*t.channels)
+	norm := make([]float64, outFrames)
+
+	prevIn, prevOut := 0, 0
+
+	for k := 0; k < numSegments; k++ {
+		outPos := k * (outFrames - t.window) / (numSegments - 1)
+		inPos := k * (inFrames - t.window) / (numSegments - 1)
+
+		if k != 0 && k != numSegments-1 {
+			natural := prevIn + (outPos - prevOut)
+			overlap := prevOut + t.window - outPos
+			inPos = t.findSimilar(mono, natural, inPos, overlap)
+		}
+
+		for n := 0; n < t.window; n++ {
+			w := t.weights[n]
+			for ch := 0; ch < t.channels; ch++ {
+				sum[(outPos+n)*t.channels+ch] += w * float64(in[(inPos+n)*t.channels+ch])
+			}
+			norm[outPos+n] += w
+		}
+
+		prevIn, prevOut = inPos, outPos
+	}
+
+	out := make([]int16, outFrames*t.channels)
+	for n := 0; n < outFrames; n++ {
+		for ch := 0; ch < t.channels; ch++ {
+			out[n*t.channels+ch] = clampInt16(sum[n*t.channels+ch] / norm[n])
+		}
+	}
+
+	return out
+}
+
+// finds segment near ideal position which best continues the natural one
+func (t *TimeStretcher) findSimilar(mono []float64, natural, ideal, overlap int) int {
+	if overlap <= 0 {
+		return ideal
+	}
+
+	lo := ideal - t.tolerance
+	if lo < 0 {
+		lo = 0
+	}
+	hi := ideal + t.tolerance
+	if hi > len(mono)-t.window {
+		hi = len(mono) - t.window
+	}
+
+	best, bestScore := ideal, math.Inf(-1)
+
+	for pos := lo; pos <= hi; pos++ {
+		corr, energy := 0.0, 0.0
+		for n := 0; n < overlap; n++ {
+			corr += mono[natural+n] * mono[pos+n]
+			energy += mono[pos+n] * mono[pos+n]
+		}
+
+		score := corr / math.Sqrt(energy+1)
+		if score > bestScore {
+			best, bestScore = pos, score
+		}
+	}
+
+	return best
+}
+
+// fallback for blocks too short for overlap-add, changes pitch
+func (t *TimeStretcher) resample(in []int16, outLen int) []int16 {
+	out := make([]int16, outLen)
+
+	inFrames := len(in) / t.channels
+	outFrames := outLen / t.channels
+
+	if inFrames == 0 {
+		return out
+	}
+
+	r := NewResampler(inFrames, outFrames, t.channels)
+	copy(out, r.Process(in))
+
+	return out
+}
+
+func clampInt16(v float64) int16 {
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(math.Round(v))
+}
@@ -0,0 +1,260 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("level%d", int(l))
+	}
+	return levelNames[l]
+}
+
+func ParseLevel(s string) (Level, error) {
+	for n, name := range levelNames {
+		if s == name {
+			return Level(n), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, should be one of: %s",
+		s, strings.Join(levelNames, ", "))
+}
+
+type Format int
+
+const (
+	// plain messages, as they were always printed
+	FormatText Format = iota
+
+	// one json object per line
+	FormatJSON
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	default:
+		return 0, fmt.Errorf("unknown log format %q, should be text or json", s)
+	}
+}
+
+// additional event data, only printed in json format
+type Fields map[string]interface{}
+
+type Config struct {
+	Format Format
+
+	// default level and overrides for specific subsystems
+	Level           Level
+	SubsystemLevels map[string]Level
+}
+
+var (
+	mu         sync.Mutex
+	output     io.Writer = os.Stderr
+	config               = Config{Level: LevelInfo}
+	subsystems           = map[string]bool{}
+)
+
+func Configure(c Config) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	config = c
+}
+
+// parses comma-separated list of levels, where each item is either default
+// level or subsystem=level, e.g. "warn,jitbuf=debug"
+func ParseLevels(s string) (Level, map[string]Level, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	defLevel := LevelInfo
+	subLevels := map[string]Level{}
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) == 1 {
+			level, err := ParseLevel(kv[0])
+			if err != nil {
+				return 0, nil, err
+			}
+			defLevel = level
+			continue
+		}
+
+		if !subsystems[kv[0]] {
+			return 0, nil, fmt.Errorf("unknown log subsystem %q, should be one of: %s",
+				kv[0], strings.Join(subsystemNames(), ", "))
+		}
+
+		level, err := ParseLevel(kv[1])
+		if err != nil {
+			return 0, nil, err
+		}
+		subLevels[kv[0]] = level
+	}
+
+	return defLevel, subLevels, nil
+}
+
+func subsystemNames() []string {
+	var names []string
+	for name := range subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// logger of one subsystem, usually created once per package or file
+type Logger struct {
+	subsystem string
+}
+
+func New(subsystem string) *Logger {
+	mu.Lock()
+	defer mu.Unlock()
+
+	subsystems[subsystem] = true
+
+	return &Logger{subsystem: subsystem}
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return l.enabled(level)
+}
+
+func (l *Logger) enabled(level Level) bool {
+	minLevel, ok := config.SubsystemLevels[l.subsystem]
+	if !ok {
+		minLevel = config.Level
+	}
+	return level >= minLevel
+}
+
+func (l *Logger) Debug(event string, fields Fields, format string, args ...interface{}) {
+	l.Log(LevelDebug, event, fields, format, args...)
+}
+
+func (l *Logger) Info(event string, fields Fields, format string, args ...interface{}) {
+	l.Log(LevelInfo, event, fields, format, args...)
+}
+
+func (l *Logger) Warn(event string, fields Fields, format string, args ...interface{}) {
+	l.Log(LevelWarn, event, fields, format, args...)
+}
+
+func (l *Logger) Error(event string, fields Fields, format string, args ...interface{}) {
+	l.Log(LevelError, event, fields, format, args...)
+}
+
+// event is a short machine-readable name, may be empty for plain messages
+func (l *Logger) Log(
+	level Level, event string, fields Fields, format string, args ...interface{},
+) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !l.enabled(level) {
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+
+	var line []byte
+	if config.Format == FormatJSON {
+		line = l.formatJSON(time.Now(), level, event, fields, msg)
+	} else {
+		switch level {
+		case LevelError:
+			msg = "Error: " + msg
+		case LevelWarn:
+			msg = "Warning: " + msg
+		}
+		line = []byte(msg + "\n")
+	}
+
+	_, _ = output.Write(line)
+}
+
+func (l *Logger) formatJSON(
+	now time.Time, level Level, event string, fields Fields, msg string,
+) []byte {
+	var buf bytes.Buffer
+
+	writeField := func(key string, value interface{}) {
+		b, err := json.Marshal(value)
+		if err != nil {
+			b, _ = json.Marshal(fmt.Sprint(value))
+		}
+		if buf.Len() == 0 {
+			buf.WriteByte('{')
+		} else {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(b)
+	}
+
+	// fixed keys first, then fields in stable order
+	writeField("time", now.Format(time.RFC3339Nano))
+	writeField("level", level.String())
+	writeField("subsystem", l.subsystem)
+	if event != "" {
+		writeField("event", event)
+	}
+	writeField("msg", msg)
+
+	var keys []string
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		writeField(key, fields[key])
+	}
+
+	buf.WriteString("}\n")
+
+	return buf.Bytes()
+}
+
+// converts duration for fields, so that it's printed as a number
+func Millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
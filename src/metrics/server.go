@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/gavv/webrtc-cli/src/log"
+)
+
+var metricsLog = log.New("metrics")
+
+// serves metrics at /metrics, collect is invoked on every scrape
+// and may be called concurrently
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+	collect  func(*Writer)
+}
+
+func NewServer(addr string, collect func(*Writer)) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("can't listen on metrics address: %s", err.Error())
+	}
+
+	s := &Server{
+		listener: listener,
+		collect:  collect,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+
+	s.server = &http.Server{Handler: mux}
+
+	metricsLog.Info("metrics_serving", log.Fields{"addr": listener.Addr().String()},
+		"Serving metrics on http://%s/metrics", listener.Addr())
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			metricsLog.Warn("metrics_failed", log.Fields{"error": err.Error()},
+				"Can't serve metrics: %s", err.Error())
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	mw := NewWriter()
+	s.collect(mw)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	_ = mw.Export(w)
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type Type string
+
+const (
+	Counter = Type("counter")
+	Gauge   = Type("gauge")
+)
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// collects samples during one scrape and formats them in prometheus
+// text exposition format, samples of the same metric are grouped together
+type Writer struct {
+	families []*family
+	byName   map[string]*family
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     Type
+	samples []sample
+}
+
+type sample struct {
+	labels []Label
+	value  float64
+}
+
+func NewWriter() *Writer {
+	return &Writer{
+		byName: make(map[string]*family),
+	}
+}
+
+// adds sample, help and type are taken from the first sample of metric
+func (w *Writer) Add(typ Type, name, help string, value float64, labels ...Label) {
+	f := w.byName[name]
+	if f == nil {
+		f = &family{name: name, help: help, typ: typ}
+		w.families = append(w.families, f)
+		w.byName[name] = f
+	}
+
+	f.samples = append(f.samples, sample{labels: labels, value: value})
+}
+
+func (w *Writer) Export(out io.Writer) error {
+	bw := bufio.NewWriter(out)
+
+	for _, f := range w.families {
+		bw.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+		bw.WriteString("# TYPE " + f.name + " " + string(f.typ) + "\n")
+
+		for _, s := range f.samples {
+			bw.WriteString(f.name)
+
+			if len(s.labels) != 0 {
+				bw.WriteString("{")
+				for n, l := range s.labels {
+					if n != 0 {
+						bw.WriteString(",")
+					}
+					bw.WriteString(l.Name + "=\"" + escapeLabel(l.Value) + "\"")
+				}
+				bw.WriteString("}")
+			}
+
+			bw.WriteString(" " + strconv.FormatFloat(s.value, 'g', -1, 64) + "\n")
+		}
+	}
+
+	return bw.Flush()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,205 @@
+package netsim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Params struct {
+	// loss probability, in percent; in burst loss model, used in good state
+	LossPercent float64
+
+	// Gilbert-Elliott burst loss model, enabled if BurstStartPercent is non-zero:
+	// probabilities of entering and leaving bad state for every packet,
+	// and loss probability in bad state, all in percent
+	BurstStartPercent float64
+	BurstEndPercent   float64
+	BurstLossPercent  float64
+
+	// fixed delay and random variation added to it
+	Delay  time.Duration
+	Jitter time.Duration
+
+	// probability of swapping packet with the next one, in percent
+	ReorderPercent float64
+
+	// probability of sending packet twice, in percent
+	DuplicatePercent float64
+
+	// link capacity in bits per second, zero for unlimited
+	Bandwidth int
+
+	// random seed, same seed and same input produce same impairments
+	Seed int64
+}
+
+// reports whether any impairment is configured
+func (p Params) Enabled() bool {
+	return p.LossPercent > 0 || p.BurstStartPercent > 0 ||
+		p.Delay > 0 || p.Jitter > 0 ||
+		p.ReorderPercent > 0 || p.DuplicatePercent > 0 ||
+		p.Bandwidth > 0
+}
+
+// formats params in the same form as accepted by ParseParams
+func (p Params) String() string {
+	var items []string
+
+	if p.LossPercent > 0 {
+		items = append(items, fmt.Sprintf("loss=%g", p.LossPercent))
+	}
+	if p.BurstStartPercent > 0 {
+		items = append(items, fmt.Sprintf("burst=%g:%g:%g",
+			p.BurstStartPercent, p.BurstEndPercent, p.BurstLossPercent))
+	}
+	if p.Delay > 0 {
+		items = append(items, fmt.Sprintf("delay=%s", p.Delay))
+	}
+	if p.Jitter > 0 {
+		items = append(items, fmt.Sprintf("jitter=%s", p.Jitter))
+	}
+	if p.ReorderPercent > 0 {
+		items = append(items, fmt.Sprintf("reorder=%g", p.ReorderPercent))
+	}
+	if p.DuplicatePercent > 0 {
+		items = append(items, fmt.Sprintf("dup=%g", p.DuplicatePercent))
+	}
+	if p.Bandwidth > 0 {
+		items = append(items, fmt.Sprintf("rate=%d", p.Bandwidth))
+	}
+
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ",")
+}
+
+// parses comma-separated list of impairments,
+// e.g. "loss=2,burst=5:30,delay=50ms,jitter=10ms,reorder=1,dup=1,rate=64k"
+func ParseParams(s string) (Params, error) {
+	var p Params
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return p, fmt.Errorf("invalid impairment %q: should be key=value", item)
+		}
+
+		key, value := kv[0], kv[1]
+
+		var err error
+
+		switch key {
+		case "loss":
+			p.LossPercent, err = parsePercent(value)
+
+		case "burst":
+			err = parseBurst(value, &p)
+
+		case "delay":
+			p.Delay, err = parseDuration(value)
+
+		case "jitter":
+			p.Jitter, err = parseDuration(value)
+
+		case "reorder":
+			p.ReorderPercent, err = parsePercent(value)
+
+		case "dup":
+			p.DuplicatePercent, err = parsePercent(value)
+
+		case "rate":
+			p.Bandwidth, err = parseBandwidth(value)
+
+		default:
+			return p, fmt.Errorf("unknown impairment %q:"+
+				" should be loss|burst|delay|jitter|reorder|dup|rate", key)
+		}
+
+		if err != nil {
+			return p, fmt.Errorf("invalid impairment %q: %s", item, err.Error())
+		}
+	}
+
+	return p, nil
+}
+
+// "start:end" or "start:end:loss"
+func parseBurst(s string, p *Params) error {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return fmt.Errorf("should be start:end or start:end:loss")
+	}
+
+	var err error
+
+	if p.BurstStartPercent, err = parsePercent(parts[0]); err != nil {
+		return err
+	}
+	if p.BurstEndPercent, err = parsePercent(parts[1]); err != nil {
+		return err
+	}
+
+	p.BurstLossPercent = 100
+	if len(parts) == 3 {
+		if p.BurstLossPercent, err = parsePercent(parts[2]); err != nil {
+			return err
+		}
+	}
+
+	if p.BurstStartPercent == 0 || p.BurstEndPercent == 0 {
+		return fmt.Errorf("transition probabilities should be non-zero")
+	}
+
+	return nil
+}
+
+func parsePercent(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse percent: %s", err.Error())
+	}
+	if v < 0 || v > 100 {
+		return 0, fmt.Errorf("percent should be in [0; 100]")
+	}
+	return v, nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration should not be negative")
+	}
+	return d, nil
+}
+
+// bits per second, with optional k or M suffix
+func parseBandwidth(s string) (int, error) {
+	mul := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mul, s = 1000, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "M"):
+		mul, s = 1000000, strings.TrimSuffix(s, "M")
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse rate: %s", err.Error())
+	}
+	if v <= 0 {
+		return 0, fmt.Errorf("rate should be positive")
+	}
+
+	return int(v * float64(mul)), nil
+}
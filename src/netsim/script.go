@@ -0,0 +1,105 @@
+package netsim
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// switches simulator to given impairments at given time since first packet
+type Step struct {
+	At     time.Duration
+	Params Params
+}
+
+// impairment timelines for both directions
+type Script struct {
+	Receive []Step
+	Send    []Step
+}
+
+type scriptFile struct {
+	Recv []scriptEntry `yaml:"recv"`
+	Send []scriptEntry `yaml:"send"`
+}
+
+type scriptEntry struct {
+	At       string `yaml:"at"`
+	Duration string `yaml:"duration"`
+	Impair   string `yaml:"impair"`
+}
+
+// reads impairment timeline from yaml file, e.g.:
+//
+//	recv:
+//	  - at: 20s
+//	    duration: 5s
+//	    impair: loss=30
+//	  - at: 25s
+//	    duration: 2s
+//	    impair: loss=100
+//
+// profile without duration is active until the next one, otherwise
+// base profile, i.e. the one specified without script, is restored
+func LoadScript(path string, recvBase, sendBase Params) (Script, error) {
+	var script Script
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return script, fmt.Errorf("can't read impairment script: %s", err.Error())
+	}
+
+	var file scriptFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return script, fmt.Errorf("can't parse impairment script: %s", err.Error())
+	}
+
+	if script.Receive, err = buildSteps(file.Recv, recvBase); err != nil {
+		return script, fmt.Errorf("invalid recv timeline: %s", err.Error())
+	}
+
+	if script.Send, err = buildSteps(file.Send, sendBase); err != nil {
+		return script, fmt.Errorf("invalid send timeline: %s", err.Error())
+	}
+
+	return script, nil
+}
+
+func buildSteps(entries []scriptEntry, base Params) ([]Step, error) {
+	var steps []Step
+
+	end := time.Duration(0)
+
+	for n, e := range entries {
+		at, err := parseDuration(e.At)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: invalid at: %s", n+1, err.Error())
+		}
+
+		if at < end {
+			return nil, fmt.Errorf("entry %d: starts before previous one ends", n+1)
+		}
+
+		params, err := ParseParams(e.Impair)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %s", n+1, err.Error())
+		}
+
+		steps = append(steps, Step{At: at, Params: params})
+		end = at
+
+		if e.Duration != "" {
+			duration, err := parseDuration(e.Duration)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: invalid duration: %s", n+1, err.Error())
+			}
+
+			end = at + duration
+			steps = append(steps, Step{At: end, Params: base})
+		}
+	}
+
+	return steps, nil
+}
@@ -0,0 +1,409 @@
+package netsim
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+
+	"github.com/gavv/webrtc-cli/src/log"
+)
+
+var netsimLog = log.New("netsim")
+
+const (
+	// packets queued longer than this on a rate-limited link are dropped
+	maxQueueDelay = time.Second
+
+	// UDP and IPv4 headers, counted towards bandwidth
+	transportOverhead = 28
+
+	outputQueueSize = 64
+
+	// how long reordered packet waits for its successor before the first
+	// packet interval is known
+	defaultPacketInterval = 20 * time.Millisecond
+)
+
+type Stats struct {
+	Packets    int
+	Lost       int
+	BurstLost  int
+	Bursts     int
+	Duplicated int
+	Reordered  int
+	Overflowed int
+
+	totalDelay time.Duration
+	numDelayed int
+}
+
+// emulates a lossy network link: packets pushed into simulator are released
+// from Packets() channel after configured delay, unless they were dropped
+type Simulator struct {
+	name   string
+	params Params
+
+	// impairment changes, time is counted from the first packet
+	schedule []Step
+	nextStep int
+	start    time.Time
+	started  bool
+
+	mu  sync.Mutex
+	rng *rand.Rand
+
+	queue   packetQueue
+	counter uint64
+
+	// burst loss model state
+	bad bool
+
+	// packet held back to be released after the next one, or at deadline
+	// if the next one doesn't arrive during one packet interval
+	held         *rtp.Packet
+	heldAt       time.Time
+	heldRelease  time.Time
+	heldDeadline time.Time
+
+	// interval between last two pushed packets
+	lastPush       time.Time
+	packetInterval time.Duration
+
+	// time when rate-limited link becomes idle
+	linkFree time.Time
+
+	stats Stats
+
+	wakeCh   chan struct{}
+	outCh    chan *rtp.Packet
+	cancelCh chan struct{}
+	doneCh   chan struct{}
+}
+
+// schedule is optional and should be sorted by time
+func NewSimulator(name string, params Params, schedule []Step) *Simulator {
+	s := &Simulator{
+		name:     name,
+		params:   params,
+		schedule: schedule,
+		rng:      rand.New(rand.NewSource(params.Seed)),
+		wakeCh:   make(chan struct{}, 1),
+		outCh:    make(chan *rtp.Packet, outputQueueSize),
+		cancelCh: make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s
+}
+
+// stops releasing packets, packets still in flight are discarded
+func (s *Simulator) Close() {
+	close(s.cancelCh)
+	<-s.doneCh
+}
+
+// returns channel with packets which passed through simulated link
+func (s *Simulator) Packets() <-chan *rtp.Packet {
+	return s.outCh
+}
+
+// schedules packet for release, or drops it
+func (s *Simulator) Push(pkt *rtp.Packet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	s.advanceSchedule(now)
+
+	if !s.lastPush.IsZero() {
+		s.packetInterval = now.Sub(s.lastPush)
+	}
+	s.lastPush = now
+
+	s.stats.Packets++
+
+	if s.lose() {
+		s.stats.Lost++
+		if s.bad {
+			s.stats.BurstLost++
+		}
+		return
+	}
+
+	release, ok := s.transmit(pkt, now)
+	if !ok {
+		s.stats.Overflowed++
+		return
+	}
+
+	if s.held == nil && s.chance(s.params.ReorderPercent) {
+		interval := s.packetInterval
+		if interval <= 0 {
+			interval = defaultPacketInterval
+		}
+
+		s.held = pkt
+		s.heldAt = now
+		s.heldRelease = release
+		s.heldDeadline = now.Add(interval)
+		s.stats.Reordered++
+
+		// let release loop know about deadline
+		s.wake()
+		return
+	}
+
+	s.enqueue(pkt, now, release)
+
+	if s.chance(s.params.DuplicatePercent) {
+		s.stats.Duplicated++
+		s.enqueue(pkt, now, release)
+	}
+
+	// held packet is released right after its successor
+	if s.held != nil {
+		s.enqueue(s.held, s.heldAt, release)
+		s.held = nil
+	}
+
+	s.wake()
+}
+
+// returns injected impairments
+func (s *Simulator) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats
+}
+
+// returns human-readable summary of injected impairments
+func (s *Simulator) Summary() string {
+	st := s.Stats()
+
+	avgDelay := time.Duration(0)
+	if st.numDelayed != 0 {
+		avgDelay = st.totalDelay / time.Duration(st.numDelayed)
+	}
+
+	return fmt.Sprintf("Simulated %s impairments (seed %d): %d packets, %d lost"+
+		" (%d in %d bursts), %d duplicated, %d reordered, %d dropped by rate limit,"+
+		" average delay %s",
+		s.name, s.params.Seed, st.Packets, st.Lost, st.BurstLost, st.Bursts,
+		st.Duplicated, st.Reordered, st.Overflowed,
+		avgDelay.Round(time.Millisecond))
+}
+
+// applies scheduled impairment changes which are due
+func (s *Simulator) advanceSchedule(now time.Time) {
+	if !s.started {
+		s.start = now
+		s.started = true
+	}
+
+	for s.nextStep < len(s.schedule) && now.Sub(s.start) >= s.schedule[s.nextStep].At {
+		step := s.schedule[s.nextStep]
+		s.nextStep++
+
+		netsimLog.Info("impairments_switched", log.Fields{
+			"direction": s.name,
+			"at_ms":     log.Millis(step.At),
+			"params":    step.Params.String(),
+		}, "Switching %s impairments at %s: %s", s.name, step.At, step.Params)
+
+		seed := s.params.Seed
+		s.params = step.Params
+		s.params.Seed = seed
+
+		if s.params.BurstStartPercent == 0 {
+			s.bad = false
+		}
+	}
+}
+
+// updates burst loss model state and decides whether packet is lost
+func (s *Simulator) lose() bool {
+	if s.params.BurstStartPercent > 0 {
+		if s.bad {
+			if s.chance(s.params.BurstEndPercent) {
+				s.bad = false
+			}
+		} else {
+			if s.chance(s.params.BurstStartPercent) {
+				s.bad = true
+				s.stats.Bursts++
+			}
+		}
+	}
+
+	if s.bad {
+		return s.chance(s.params.BurstLossPercent)
+	}
+	return s.chance(s.params.LossPercent)
+}
+
+// computes when packet arrives to the other end of the link
+func (s *Simulator) transmit(pkt *rtp.Packet, now time.Time) (time.Time, bool) {
+	departure := now
+
+	if s.params.Bandwidth > 0 {
+		if s.linkFree.After(departure) {
+			departure = s.linkFree
+		}
+
+		if departure.Sub(now) > maxQueueDelay {
+			return time.Time{}, false
+		}
+
+		size := pkt.Header.MarshalSize() + len(pkt.Payload) + transportOverhead
+		departure = departure.Add(
+			time.Duration(size*8) * time.Second / time.Duration(s.params.Bandwidth))
+
+		s.linkFree = departure
+	}
+
+	delay := s.params.Delay
+	if s.params.Jitter > 0 {
+		delay += time.Duration((s.rng.Float64()*2 - 1) * float64(s.params.Jitter))
+		if delay < 0 {
+			delay = 0
+		}
+	}
+
+	return departure.Add(delay), true
+}
+
+func (s *Simulator) enqueue(pkt *rtp.Packet, now, release time.Time) {
+	s.counter++
+	heap.Push(&s.queue, queueEntry{
+		pkt:     pkt,
+		release: release,
+		order:   s.counter,
+	})
+
+	s.stats.totalDelay += release.Sub(now)
+	s.stats.numDelayed++
+}
+
+func (s *Simulator) wake() {
+	select {
+	case s.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Simulator) chance(percent float64) bool {
+	if percent <= 0 {
+		return false
+	}
+	return s.rng.Float64()*100 < percent
+}
+
+func (s *Simulator) run() {
+	defer close(s.doneCh)
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		pkt, wait := s.next(time.Now())
+
+		if pkt != nil {
+			select {
+			case s.outCh <- pkt:
+			case <-s.cancelCh:
+				return
+			}
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-s.wakeCh:
+		case <-s.cancelCh:
+			return
+		}
+	}
+}
+
+// returns packet ready for release, or time to wait for the next one
+func (s *Simulator) next(now time.Time) (*rtp.Packet, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// successor didn't arrive in time, release held packet on its own
+	if s.held != nil && !now.Before(s.heldDeadline) {
+		release := s.heldRelease
+		if release.Before(s.heldDeadline) {
+			release = s.heldDeadline
+		}
+		s.enqueue(s.held, s.heldAt, release)
+		s.held = nil
+	}
+
+	wait := time.Hour
+	if s.held != nil {
+		wait = s.heldDeadline.Sub(now)
+	}
+
+	if len(s.queue) == 0 {
+		return nil, wait
+	}
+
+	if w := s.queue[0].release.Sub(now); w > 0 {
+		if w < wait {
+			wait = w
+		}
+		return nil, wait
+	}
+
+	return heap.Pop(&s.queue).(queueEntry).pkt, 0
+}
+
+type queueEntry struct {
+	pkt     *rtp.Packet
+	release time.Time
+
+	// keeps packets with equal release time in push order
+	order uint64
+}
+
+// min-heap by release time
+type packetQueue []queueEntry
+
+func (q packetQueue) Len() int { return len(q) }
+
+func (q packetQueue) Less(i, j int) bool {
+	if q[i].release.Equal(q[j].release) {
+		return q[i].order < q[j].order
+	}
+	return q[i].release.Before(q[j].release)
+}
+
+func (q packetQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *packetQueue) Push(x interface{}) {
+	*q = append(*q, x.(queueEntry))
+}
+
+func (q *packetQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
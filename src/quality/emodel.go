@@ -0,0 +1,166 @@
+package quality
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// equipment impairment of codec, see ITU-T G.113 appendix I
+type Codec struct {
+	Name string
+
+	// impairment without losses
+	Ie float64
+
+	// robustness against random packet loss
+	Bpl float64
+
+	// algorithmic delay in addition to frame length
+	Lookahead time.Duration
+}
+
+// there are no standardized values for opus; it's considered transparent
+// in narrowband scale, and its PLC is at least as good as that of G.711
+var Opus = Codec{
+	Name:      "opus",
+	Ie:        0,
+	Bpl:       25.1,
+	Lookahead: 6500 * time.Microsecond,
+}
+
+type Input struct {
+	Codec Codec
+
+	// fraction of lost packets, from 0 to 1
+	Loss float64
+
+	// mouth-to-ear delay
+	Delay time.Duration
+}
+
+type Score struct {
+	R   float64
+	MOS float64
+}
+
+func (s Score) String() string {
+	return fmt.Sprintf("R-factor %.1f, MOS %.2f", s.R, s.MOS)
+}
+
+// default values of G.107 parameters
+const (
+	defSLR  = 8
+	defRLR  = 2
+	defSTMR = 15
+	defDs   = 3
+	defDr   = 3
+	defTELR = 65
+	defWEPL = 110
+	defNc   = -70
+	defNfor = -64
+	defPs   = 35
+	defPr   = 35
+	defQdu  = 1
+)
+
+// computes R-factor using ITU-T G.107 E-model with default parameters,
+// assuming that talker echo path delay equals mouth-to-ear delay and
+// that loss is random
+func Estimate(in Input) Score {
+	ta := float64(in.Delay) / float64(time.Millisecond)
+	if ta < 0 {
+		ta = 0
+	}
+
+	no := noiseLevel()
+	ro := 15 - 1.5*(defSLR+no)
+
+	r := ro -
+		simultaneousImpairment(ro, no, ta) -
+		delayImpairment(ro, no, ta) -
+		equipmentImpairment(in.Codec, in.Loss)
+
+	return Score{R: r, MOS: MOS(r)}
+}
+
+// converts R-factor to estimated mean opinion score
+func MOS(r float64) float64 {
+	switch {
+	case r < 0:
+		return 1
+	case r > 100:
+		return 4.5
+	default:
+		return 1 + 0.035*r + r*(r-60)*(100-r)*7e-6
+	}
+}
+
+// sums circuit noise, room noise at send and receive side, and noise floor
+func noiseLevel() float64 {
+	olr := float64(defSLR + defRLR)
+	lstr := float64(defSTMR + defDr)
+
+	nos := defPs - defSLR - defDs - 100 + 0.004*math.Pow(defPs-olr-defDs-14, 2)
+	pre := defPr + 10*math.Log10(1+math.Pow(10, (10-lstr)/10))
+	nor := defRLR - 121 + pre + 0.008*math.Pow(pre-35, 2)
+	nfo := float64(defNfor + defRLR)
+
+	return 10 * math.Log10(math.Pow(10, defNc/10.0)+math.Pow(10, nos/10)+
+		math.Pow(10, nor/10)+math.Pow(10, nfo/10))
+}
+
+// returns impairments caused by loudness, sidetone, and quantizing distortion
+func simultaneousImpairment(ro, no, t float64) float64 {
+	olr := float64(defSLR + defRLR)
+
+	xolr := olr + 0.2*(64+no-defRLR)
+	iolr := 20 * (math.Pow(1+math.Pow(xolr/8, 8), 1/8.0) - xolr/8)
+
+	stmro := -10 * math.Log10(math.Pow(10, -defSTMR/10.0)+
+		math.Exp(-t/4)*math.Pow(10, -defTELR/10.0))
+	ist := 12*math.Pow(1+math.Pow((stmro-13)/6, 8), 1/8.0) -
+		28*math.Pow(1+math.Pow((stmro+1)/19.4, 35), 1/35.0) -
+		13*math.Pow(1+math.Pow((stmro-3)/33, 13), 1/13.0) + 29
+
+	q := 37 - 15*math.Log10(defQdu)
+	g := 1.07 + 0.258*q + 0.0602*q*q
+	y := (ro-100)/15 + 46/8.4 - g/9
+	z := 46/30.0 - g/40
+	iq := 15 * math.Log10(1+math.Pow(10, y)+math.Pow(10, z))
+
+	return iolr + ist + iq
+}
+
+// returns impairments caused by talker echo, listener echo, and too long delay
+func delayImpairment(ro, no, ta float64) float64 {
+	t := ta
+	tr := 2 * ta
+
+	// talker echo
+	terv := defTELR - 40*math.Log10((1+t/10)/(1+t/150)) + 6*math.Exp(-0.3*t*t)
+	roe := -1.5 * (no - defRLR)
+	re := 80 + 2.5*(terv-14)
+	idte := ((roe-re)/2 + math.Sqrt((roe-re)*(roe-re)/4+100) - 1) * (1 - math.Exp(-t))
+
+	// listener echo
+	rle := 10.5 * (defWEPL + 7) * math.Pow(tr+1, -0.25)
+	idle := (ro-rle)/2 + math.Sqrt((ro-rle)*(ro-rle)/4+169)
+
+	// absolute delay
+	idd := 0.0
+	if ta > 100 {
+		x := math.Log10(ta/100) / math.Log10(2)
+		idd = 25 * (math.Pow(1+math.Pow(x, 6), 1/6.0) -
+			3*math.Pow(1+math.Pow(x/3, 6), 1/6.0) + 2)
+	}
+
+	return idte + idle + idd
+}
+
+// returns effective equipment impairment for random packet loss
+func equipmentImpairment(codec Codec, loss float64) float64 {
+	ppl := math.Max(0, math.Min(1, loss)) * 100
+
+	return codec.Ie + (95-codec.Ie)*ppl/(ppl+codec.Bpl)
+}
@@ -0,0 +1,310 @@
+package rtc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	pcapMagicNano = 0xa1b23c4d
+
+	pcapLinkNull     = 0
+	pcapLinkEthernet = 1
+	pcapLinkSLL      = 113
+	pcapLinkIPv4     = 228
+	pcapLinkIPv6     = 229
+)
+
+// packet read from capture file
+type CapturedPacket struct {
+	Data    []byte
+	Arrival time.Time
+	IsRTCP  bool
+}
+
+// reads packets from pcap file written by --capture-rtp or by other tools,
+// or from rtpdump file; only UDP packets are used from pcap
+type CaptureReader struct {
+	fp *os.File
+	rd *bufio.Reader
+
+	format captureFormat
+
+	// pcap
+	order    binary.ByteOrder
+	nanosec  bool
+	linkType uint32
+
+	// rtpdump
+	start time.Time
+}
+
+func OpenCapture(path string) (*CaptureReader, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open capture file: %s", err.Error())
+	}
+
+	r := &CaptureReader{
+		fp: fp,
+		rd: bufio.NewReader(fp),
+	}
+
+	if err := r.readHeader(); err != nil {
+		fp.Close()
+		return nil, fmt.Errorf("can't read capture file header: %s", err.Error())
+	}
+
+	return r, nil
+}
+
+func (r *CaptureReader) Close() error {
+	return r.fp.Close()
+}
+
+// returns next RTP or RTCP packet, or io.EOF
+func (r *CaptureReader) ReadPacket() (CapturedPacket, error) {
+	for {
+		var pkt CapturedPacket
+		var ok bool
+		var err error
+
+		if r.format == captureRtpdump {
+			pkt, ok, err = r.readRtpdumpPacket()
+		} else {
+			pkt, ok, err = r.readPcapPacket()
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return pkt, io.EOF
+		}
+		if err != nil {
+			return pkt, fmt.Errorf("can't read capture file: %s", err.Error())
+		}
+
+		if ok {
+			return pkt, nil
+		}
+	}
+}
+
+func (r *CaptureReader) readHeader() error {
+	magic, err := r.rd.Peek(len(rtpdumpMagic))
+	if err != nil {
+		return err
+	}
+
+	if string(magic) == rtpdumpMagic {
+		r.format = captureRtpdump
+		return r.readRtpdumpHeader()
+	}
+
+	r.format = capturePcap
+	return r.readPcapHeader()
+}
+
+func (r *CaptureReader) readPcapHeader() error {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r.rd, hdr); err != nil {
+		return err
+	}
+
+	switch {
+	case binary.LittleEndian.Uint32(hdr) == pcapMagic:
+		r.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(hdr) == pcapMagic:
+		r.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(hdr) == pcapMagicNano:
+		r.order, r.nanosec = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(hdr) == pcapMagicNano:
+		r.order, r.nanosec = binary.BigEndian, true
+	default:
+		return errors.New("not a pcap or rtpdump file (note that pcapng is not supported)")
+	}
+
+	r.linkType = r.order.Uint32(hdr[20:]) & 0xffff
+
+	switch r.linkType {
+	case pcapLinkNull, pcapLinkEthernet, pcapLinkSLL,
+		pcapLinkRaw, pcapLinkIPv4, pcapLinkIPv6:
+	default:
+		return fmt.Errorf("unsupported pcap link type %d", r.linkType)
+	}
+
+	return nil
+}
+
+func (r *CaptureReader) readPcapPacket() (CapturedPacket, bool, error) {
+	var pkt CapturedPacket
+
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r.rd, hdr); err != nil {
+		return pkt, false, err
+	}
+
+	sec := int64(r.order.Uint32(hdr[0:]))
+	frac := int64(r.order.Uint32(hdr[4:]))
+	capLen := r.order.Uint32(hdr[8:])
+
+	if capLen > pcapSnapLen*4 {
+		return pkt, false, fmt.Errorf("invalid packet length %d", capLen)
+	}
+
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(r.rd, data); err != nil {
+		return pkt, false, err
+	}
+
+	if r.nanosec {
+		pkt.Arrival = time.Unix(sec, frac)
+	} else {
+		pkt.Arrival = time.Unix(sec, frac*1000)
+	}
+
+	payload, ok := r.extractUDP(data)
+	if !ok || len(payload) < 2 || payload[0]>>6 != 2 {
+		// not RTP or RTCP
+		return pkt, false, nil
+	}
+
+	pkt.Data = payload
+	pkt.IsRTCP = isRTCP(payload)
+
+	return pkt, true, nil
+}
+
+// strips link, IP, and UDP headers
+func (r *CaptureReader) extractUDP(data []byte) ([]byte, bool) {
+	switch r.linkType {
+	case pcapLinkNull:
+		if len(data) < 4 {
+			return nil, false
+		}
+		data = data[4:]
+
+	case pcapLinkEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType := binary.BigEndian.Uint16(data[12:])
+		data = data[14:]
+		// skip vlan tags
+		for etherType == 0x8100 && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+
+	case pcapLinkSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		data = data[16:]
+	}
+
+	if len(data) < 1 {
+		return nil, false
+	}
+
+	var proto byte
+
+	switch data[0] >> 4 {
+	case 4:
+		hdrLen := int(data[0]&0x0f) * 4
+		if len(data) < hdrLen || hdrLen < ipv4HeaderLen {
+			return nil, false
+		}
+		// fragments are not supported
+		if binary.BigEndian.Uint16(data[6:])&0x3fff != 0 {
+			return nil, false
+		}
+		proto = data[9]
+		data = data[hdrLen:]
+
+	case 6:
+		if len(data) < ipv6HeaderLen {
+			return nil, false
+		}
+		// extension headers are not supported
+		proto = data[6]
+		data = data[ipv6HeaderLen:]
+
+	default:
+		return nil, false
+	}
+
+	if proto != 17 || len(data) < udpHeaderLen {
+		return nil, false
+	}
+
+	udpLen := int(binary.BigEndian.Uint16(data[4:]))
+	if udpLen < udpHeaderLen || udpLen > len(data) {
+		return nil, false
+	}
+
+	return data[udpHeaderLen:udpLen], true
+}
+
+func (r *CaptureReader) readRtpdumpHeader() error {
+	line, err := r.rd.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+
+	if !bytes.HasPrefix(line, []byte(rtpdumpMagic)) {
+		return errors.New("invalid rtpdump header")
+	}
+
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r.rd, hdr); err != nil {
+		return err
+	}
+
+	r.start = time.Unix(int64(binary.BigEndian.Uint32(hdr[0:])),
+		int64(binary.BigEndian.Uint32(hdr[4:]))*1000)
+
+	return nil
+}
+
+func (r *CaptureReader) readRtpdumpPacket() (CapturedPacket, bool, error) {
+	var pkt CapturedPacket
+
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(r.rd, hdr); err != nil {
+		return pkt, false, err
+	}
+
+	length := int(binary.BigEndian.Uint16(hdr[0:]))
+	plen := int(binary.BigEndian.Uint16(hdr[2:]))
+	offset := binary.BigEndian.Uint32(hdr[4:])
+
+	if length < len(hdr) {
+		return pkt, false, fmt.Errorf("invalid packet length %d", length)
+	}
+
+	data := make([]byte, length-len(hdr))
+	if _, err := io.ReadFull(r.rd, data); err != nil {
+		return pkt, false, err
+	}
+
+	// headers-only dumps are not supported
+	if plen > len(data) {
+		return pkt, false, nil
+	}
+
+	pkt.Data = data
+	pkt.Arrival = r.start.Add(time.Duration(offset) * time.Millisecond)
+	pkt.IsRTCP = plen == 0
+
+	return pkt, true, nil
+}
+
+// RTCP packet types are in range [192; 223], see RFC 5761
+func isRTCP(b []byte) bool {
+	return len(b) >= 2 && b[1] >= 192 && b[1] <= 223
+}
@@ -0,0 +1,278 @@
+package rtc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pcapMagic    = 0xa1b2c3d4
+	pcapSnapLen  = 65535
+	pcapLinkRaw  = 101 // raw IPv4 or IPv6 packets
+	rtpdumpMagic = "#!rtpplay1.0"
+
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	udpHeaderLen  = 8
+)
+
+type captureFormat int
+
+const (
+	capturePcap captureFormat = iota
+	captureRtpdump
+)
+
+// writes received RTP and RTCP packets with arrival times into pcap file,
+// with synthesized IP and UDP headers, or into rtpdump file (see rtptools)
+type captureWriter struct {
+	mu sync.Mutex
+
+	fp  *os.File
+	buf *bufio.Writer
+
+	format captureFormat
+
+	// addresses of selected ICE candidate pair, if known
+	local  *net.UDPAddr
+	remote *net.UDPAddr
+
+	started bool
+	start   time.Time
+
+	ipID uint16
+}
+
+// format is chosen by file extension, pcap by default
+func newCaptureWriter(path string) (*captureWriter, error) {
+	fp, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open capture file: %s", err.Error())
+	}
+
+	w := &captureWriter{
+		fp:     fp,
+		buf:    bufio.NewWriter(fp),
+		format: capturePcap,
+		local:  &net.UDPAddr{IP: net.IPv4zero},
+		remote: &net.UDPAddr{IP: net.IPv4zero},
+	}
+
+	if strings.HasSuffix(path, ".rtpdump") {
+		w.format = captureRtpdump
+	}
+
+	return w, nil
+}
+
+func (w *captureWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.fp == nil {
+		return nil
+	}
+
+	err := w.buf.Flush()
+	if cerr := w.fp.Close(); err == nil {
+		err = cerr
+	}
+	w.fp = nil
+
+	if err != nil {
+		return fmt.Errorf("can't close capture file: %s", err.Error())
+	}
+
+	return nil
+}
+
+// sets addresses used in synthesized headers, should be called before
+// first packet
+func (w *captureWriter) setAddresses(local, remote *net.UDPAddr) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.local = local
+	w.remote = remote
+}
+
+func (w *captureWriter) writeRTP(b []byte, arrival time.Time) error {
+	return w.writePacket(b, arrival, false)
+}
+
+func (w *captureWriter) writeRTCP(b []byte, arrival time.Time) error {
+	return w.writePacket(b, arrival, true)
+}
+
+func (w *captureWriter) writePacket(b []byte, arrival time.Time, isRTCP bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.fp == nil {
+		return nil
+	}
+
+	if !w.started {
+		w.start = arrival
+		w.started = true
+
+		if err := w.writeHeader(); err != nil {
+			return fmt.Errorf("can't write capture file: %s", err.Error())
+		}
+	}
+
+	var err error
+	if w.format == captureRtpdump {
+		err = w.writeRtpdumpPacket(b, arrival, isRTCP)
+	} else {
+		err = w.writePcapPacket(b, arrival)
+	}
+
+	if err != nil {
+		return fmt.Errorf("can't write capture file: %s", err.Error())
+	}
+
+	return nil
+}
+
+func (w *captureWriter) writeHeader() error {
+	if w.format == captureRtpdump {
+		return w.writeRtpdumpHeader()
+	}
+	return w.writePcapHeader()
+}
+
+func (w *captureWriter) writePcapHeader() error {
+	hdr := make([]byte, 24)
+
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], 2) // version major
+	binary.LittleEndian.PutUint16(hdr[6:], 4) // version minor
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkRaw)
+
+	_, err := w.buf.Write(hdr)
+	return err
+}
+
+func (w *captureWriter) writePcapPacket(b []byte, arrival time.Time) error {
+	pkt := w.buildIPPacket(b)
+
+	hdr := make([]byte, 16)
+
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(arrival.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(arrival.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(pkt)))
+
+	if _, err := w.buf.Write(hdr); err != nil {
+		return err
+	}
+
+	_, err := w.buf.Write(pkt)
+	return err
+}
+
+// wraps payload into UDP datagram sent from remote to local address;
+// UDP checksum is left zero, which means "not computed"
+func (w *captureWriter) buildIPPacket(payload []byte) []byte {
+	udpLen := udpHeaderLen + len(payload)
+
+	src, dst := w.remote.IP.To4(), w.local.IP.To4()
+
+	var pkt, udp []byte
+
+	if src != nil && dst != nil {
+		pkt = make([]byte, ipv4HeaderLen+udpLen)
+
+		ip := pkt[:ipv4HeaderLen]
+		ip[0] = 0x45 // version 4, 5 words header
+		binary.BigEndian.PutUint16(ip[2:], uint16(len(pkt)))
+		binary.BigEndian.PutUint16(ip[4:], w.ipID)
+		ip[8] = 64 // ttl
+		ip[9] = 17 // udp
+		copy(ip[12:], src)
+		copy(ip[16:], dst)
+		binary.BigEndian.PutUint16(ip[10:], ipChecksum(ip))
+
+		w.ipID++
+
+		udp = pkt[ipv4HeaderLen:]
+	} else {
+		pkt = make([]byte, ipv6HeaderLen+udpLen)
+
+		ip := pkt[:ipv6HeaderLen]
+		ip[0] = 0x60 // version 6
+		binary.BigEndian.PutUint16(ip[4:], uint16(udpLen))
+		ip[6] = 17 // udp
+		ip[7] = 64 // hop limit
+		copy(ip[8:], w.remote.IP.To16())
+		copy(ip[24:], w.local.IP.To16())
+
+		udp = pkt[ipv6HeaderLen:]
+	}
+
+	binary.BigEndian.PutUint16(udp[0:], uint16(w.remote.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(w.local.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpLen))
+	copy(udp[udpHeaderLen:], payload)
+
+	return pkt
+}
+
+func ipChecksum(hdr []byte) uint16 {
+	var sum uint32
+	for n := 0; n < len(hdr); n += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[n:]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+func (w *captureWriter) writeRtpdumpHeader() error {
+	if _, err := fmt.Fprintf(w.buf, "%s %s/%d\n",
+		rtpdumpMagic, w.remote.IP.String(), w.remote.Port); err != nil {
+		return err
+	}
+
+	hdr := make([]byte, 16)
+
+	binary.BigEndian.PutUint32(hdr[0:], uint32(w.start.Unix()))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(w.start.Nanosecond()/1000))
+	if src := w.remote.IP.To4(); src != nil {
+		copy(hdr[8:], src)
+	}
+	binary.BigEndian.PutUint16(hdr[12:], uint16(w.remote.Port))
+
+	_, err := w.buf.Write(hdr)
+	return err
+}
+
+func (w *captureWriter) writeRtpdumpPacket(b []byte, arrival time.Time, isRTCP bool) error {
+	hdr := make([]byte, 8)
+
+	// packet length is zero for RTCP
+	plen := len(b)
+	if isRTCP {
+		plen = 0
+	}
+
+	binary.BigEndian.PutUint16(hdr[0:], uint16(len(hdr)+len(b)))
+	binary.BigEndian.PutUint16(hdr[2:], uint16(plen))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(arrival.Sub(w.start)/time.Millisecond))
+
+	if _, err := w.buf.Write(hdr); err != nil {
+		return err
+	}
+
+	_, err := w.buf.Write(b)
+	return err
+}
@@ -2,13 +2,30 @@ package rtc
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/pion/rtp"
 	"golang.org/x/time/rate"
 	"gopkg.in/gavv/opus.v2"
+
+	"github.com/gavv/webrtc-cli/src/dsp"
+	"github.com/gavv/webrtc-cli/src/log"
 )
 
+var decoderLog = log.New("decoder")
+
+// totals since start
+type DecoderStats struct {
+	// samples missing in received stream because of lost or late packets
+	LostSamples int
+	// samples concealed because no packets arrived in time for playback;
+	// counted separately, since packets for them may still arrive, and are
+	// skipped then, or may be lost
+	LateSamples int
+	// samples restored using FEC and generated using PLC
+	FECSamples int
+	PLCSamples int
+}
+
 type depacketizer struct {
 	decoder *opus.Decoder
 
@@ -22,8 +39,16 @@ type depacketizer struct {
 	rate     int
 	channels int
 
-	nFEC    int
+	// continuation of concealed samples, crossfaded with next decoded samples
+	concealTail []int16
+
+	// samples generated by PLC beyond requested size, returned by next conceal
+	concealExtra []int16
+
+	nFEC int
 	nPLC int
+
+	stats DecoderStats
 }
 
 func newDepacketizer(
@@ -36,7 +61,7 @@ func newDepacketizer(
 		channels:  channels,
 	}
 
-	if debug {
+	if debug || decoderLog.Enabled(log.LevelDebug) {
 		d.logLim = rate.NewLimiter(rate.Limit(0.5), 1)
 	} else {
 		d.logLim = rate.NewLimiter(rate.Limit(0.01), 1)
@@ -46,6 +71,12 @@ func newDepacketizer(
 }
 
 func (d *depacketizer) getSamples(newPacket *rtp.Packet) ([]int16, error) {
+	// extra concealed samples weren't played, so they're not skipped
+	if d.concealExtra != nil {
+		d.lastTimestamp -= d.samplesToTimestamp(len(d.concealExtra))
+		d.concealExtra = nil
+	}
+
 	buf, err := d.decodeSamples(newPacket)
 	if err != nil {
 		return nil, err
@@ -54,7 +85,7 @@ func (d *depacketizer) getSamples(newPacket *rtp.Packet) ([]int16, error) {
 	if d.lastPacket == nil {
 		d.lastTimestamp = newPacket.Timestamp
 	}
-	d.lastTimestamp += uint32(len(buf) / d.channels)
+	d.lastTimestamp += d.samplesToTimestamp(len(buf))
 	d.lastPacket = newPacket
 
 	if len(buf) == 0 {
@@ -75,6 +106,14 @@ func (d *depacketizer) decodeSamples(newPacket *rtp.Packet) ([]int16, error) {
 		return nil, fmt.Errorf("can't decode opus frame: %s", err.Error())
 	}
 
+	// smooth transition from PLC to decoded audio
+	if d.concealTail != nil {
+		if len(current) >= len(d.concealTail) {
+			dsp.Crossfade(d.concealTail, current[:len(d.concealTail)], d.channels)
+		}
+		d.concealTail = nil
+	}
+
 	if current == nil {
 		return missing, nil
 	}
@@ -102,7 +141,7 @@ func (d *depacketizer) decodeNewSamples(newPacket *rtp.Packet) ([]int16, error)
 
 	timestampDiff := 0
 	if d.lastPacket != nil {
-		timestampDiff = int(int32(newPacket.Timestamp - d.lastTimestamp))
+		timestampDiff = d.timestampToSamples(newPacket.Timestamp-d.lastTimestamp) / d.channels
 	}
 
 	// new packet is completely after previous
@@ -126,11 +165,13 @@ func (d *depacketizer) decodeMissingSamples(newPacket *rtp.Packet) ([]int16, err
 	}
 
 	// how much samples are missing between the previous and new packet
-	missingSamples := int(int32(newPacket.Timestamp-d.lastTimestamp)) * d.channels
+	missingSamples := d.timestampToSamples(newPacket.Timestamp - d.lastTimestamp)
 	if missingSamples <= 0 {
 		return nil, nil
 	}
 
+	d.stats.LostSamples += missingSamples
+
 	// get exact size of the last packet, as required by DecodeFEC
 	lastPacketLen, err := d.decoder.LastPacketDuration()
 	if err != nil || lastPacketLen == 0 {
@@ -148,7 +189,9 @@ func (d *depacketizer) decodeMissingSamples(newPacket *rtp.Packet) ([]int16, err
 	right := d.decodeFEC(newPacket, lastPacketLen)
 
 	// fallback to PLC if FEC is disabled or failed
+	concealed := false
 	if len(right) == 0 {
+		concealed = true
 		if missingSamples > lastPacketLen {
 			right = d.decodePLC(lastPacketLen)
 		} else {
@@ -161,9 +204,22 @@ func (d *depacketizer) decodeMissingSamples(newPacket *rtp.Packet) ([]int16, err
 		right = right[len(right)-missingSamples:]
 	}
 
+	if !concealed {
+		d.nFEC += len(right)
+		d.stats.FECSamples += len(right)
+	}
+
+	// continue concealment a bit further, so that it can be crossfaded
+	// with the new packet
+	if concealed {
+		d.concealTail = make([]int16, dsp.RampSamples(d.rate, d.channels))
+		_ = d.decoder.DecodePLC(d.concealTail)
+	}
+
 	if d.logLim.Allow() {
-		fmt.Fprintf(os.Stderr, "Recovered %d samples using FEC and %d samples using PLC\n",
-			d.nFEC, d.nPLC)
+		decoderLog.Debug("samples_recovered",
+			log.Fields{"fec_samples": d.nFEC, "plc_samples": d.nPLC},
+			"Recovered %d samples using FEC and %d samples using PLC", d.nFEC, d.nPLC)
 		d.nFEC, d.nPLC = 0, 0
 	}
 
@@ -175,6 +231,64 @@ func (d *depacketizer) decodeMissingSamples(newPacket *rtp.Packet) ([]int16, err
 	}
 }
 
+// produces given number of samples using PLC when no packets arrive,
+// and advances timestamp so that late packets for this period are skipped;
+// PLC works with fixed granularity, so extra samples are kept for next call
+func (d *depacketizer) conceal(numSamples int) []int16 {
+	// nothing to conceal yet
+	if d.lastPacket == nil {
+		return nil
+	}
+
+	if missing := numSamples - len(d.concealExtra); missing > 0 {
+		granularity := d.rate / plcGranularityPerSec * d.channels
+		if rem := missing % granularity; rem != 0 {
+			missing += granularity - rem
+		}
+
+		pcm := make([]int16, missing)
+		_ = d.decoder.DecodePLC(pcm)
+
+		d.concealExtra = append(d.concealExtra, pcm...)
+		d.lastTimestamp += d.samplesToTimestamp(missing)
+	}
+
+	pcm := d.concealExtra[:numSamples:numSamples]
+
+	d.concealExtra = d.concealExtra[numSamples:]
+	if len(d.concealExtra) == 0 {
+		d.concealExtra = nil
+	}
+
+	d.nPLC += numSamples
+	d.stats.PLCSamples += numSamples
+	d.stats.LateSamples += numSamples
+
+	return pcm
+}
+
+// produces given number of samples using PLC without advancing timestamp,
+// e.g. while buffer is refilled after stream resumed
+func (d *depacketizer) extrapolate(numSamples int) []int16 {
+	if d.lastPacket == nil {
+		return nil
+	}
+
+	size := numSamples
+	granularity := d.rate / plcGranularityPerSec * d.channels
+	if rem := size % granularity; rem != 0 {
+		size += granularity - rem
+	}
+
+	pcm := make([]int16, size)
+	_ = d.decoder.DecodePLC(pcm)
+
+	d.nPLC += numSamples
+	d.stats.PLCSamples += numSamples
+
+	return pcm[:numSamples]
+}
+
 func (d *depacketizer) decodeFEC(newPacket *rtp.Packet, lastPacketLen int) []int16 {
 	if !d.enableFEC {
 		return nil
@@ -185,8 +299,6 @@ func (d *depacketizer) decodeFEC(newPacket *rtp.Packet, lastPacketLen int) []int
 		return nil
 	}
 
-	d.nFEC += len(pcm)
-
 	return pcm
 }
 
@@ -196,6 +308,18 @@ func (d *depacketizer) decodePLC(numSamples int) []int16 {
 	_ = d.decoder.DecodePLC(pcm)
 
 	d.nPLC += len(pcm)
+	d.stats.PLCSamples += len(pcm)
 
 	return pcm
 }
+
+// opus RTP timestamps use 48kHz clock regardless of sample rate, see RFC 7587;
+// samples are interleaved
+func (d *depacketizer) samplesToTimestamp(numSamples int) uint32 {
+	return uint32(int64(numSamples/d.channels) * opusGranuleRate / int64(d.rate))
+}
+
+// converts signed timestamp difference to number of interleaved samples
+func (d *depacketizer) timestampToSamples(diff uint32) int {
+	return int(int64(int32(diff))*int64(d.rate)/opusGranuleRate) * d.channels
+}
@@ -0,0 +1,274 @@
+package rtc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+
+	"github.com/pion/rtp"
+
+	"github.com/gavv/webrtc-cli/src/log"
+)
+
+var recordLog = log.New("record")
+
+const (
+	oggPageHeaderLen = 27
+
+	oggFlagBOS = 0x02
+	oggFlagEOS = 0x04
+
+	// typical encoder lookahead of libopus
+	oggPreSkip = 312
+
+	// longer timestamp jumps are treated as discontinuity, e.g. when remote
+	// peer paused or restarted its stream, and are not filled with gap
+	oggMaxJump = 5 * opusGranuleRate
+)
+
+// writes received opus payloads into ogg container as-is, see RFC 7845;
+// opus RTP timestamps always use 48kHz clock, see RFC 7587
+type oggWriter struct {
+	mu sync.Mutex
+
+	fp *os.File
+
+	channels int
+
+	serial  uint32
+	pageSeq uint32
+
+	// last written packet, kept until next one to set EOS flag on close
+	pending []byte
+
+	// granule position at the end of pending packet
+	granule uint64
+
+	started       bool
+	nextTimestamp uint32
+	lastTOC       byte
+
+	nGapSamples int
+	nLate       int
+	nJumps      int
+
+	crcTable [256]uint32
+}
+
+func newOggWriter(path string, channels int) (*oggWriter, error) {
+	fp, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open ogg file: %s", err.Error())
+	}
+
+	w := &oggWriter{
+		fp:       fp,
+		channels: channels,
+		serial:   rand.Uint32(),
+	}
+
+	w.initCRC()
+
+	if err := w.writeHeaders(); err != nil {
+		fp.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *oggWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.fp == nil {
+		return nil
+	}
+
+	if w.nGapSamples != 0 || w.nLate != 0 || w.nJumps != 0 {
+		recordLog.Info("recording_finished",
+			log.Fields{
+				"lost_samples":    w.nGapSamples,
+				"late_packets":    w.nLate,
+				"timestamp_jumps": w.nJumps,
+			},
+			"Recorded opus stream with %d lost samples, %d late packets skipped,"+
+				" and %d timestamp jumps", w.nGapSamples, w.nLate, w.nJumps)
+	}
+
+	var err error
+	if w.pending != nil {
+		err = w.writePage(w.pending, w.granule, oggFlagEOS)
+		w.pending = nil
+	}
+
+	if cerr := w.fp.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("can't close ogg file: %s", cerr.Error())
+	}
+
+	w.fp = nil
+
+	return err
+}
+
+func (w *oggWriter) writePacket(pkt *rtp.Packet) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.fp == nil || len(pkt.Payload) == 0 {
+		return nil
+	}
+
+	packetSamples, err := opusPacketSamples(pkt.Payload)
+	if err != nil {
+		// not a valid opus packet, can't be stored in ogg
+		return nil
+	}
+
+	if w.started {
+		timestampDiff := int(int32(pkt.Timestamp - w.nextTimestamp))
+
+		switch {
+		// discontinuity, continue recording right after previous packet
+		case timestampDiff > oggMaxJump || timestampDiff < -oggMaxJump:
+			w.nJumps++
+
+		// late or duplicate packet, granule positions can't go back
+		case timestampDiff < 0:
+			w.nLate++
+			return nil
+
+		// some packets were lost, fill their place with empty frames
+		case timestampDiff > 0:
+			if err := w.writeGap(timestampDiff); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.started = true
+	w.lastTOC = pkt.Payload[0]
+	w.nextTimestamp = pkt.Timestamp + uint32(packetSamples)
+
+	return w.appendPacket(pkt.Payload, packetSamples)
+}
+
+// writes packets consisting of TOC byte only; the decoder handles them
+// like lost packets, see RFC 6716, section 3.2.1
+func (w *oggWriter) writeGap(gapSamples int) error {
+	// CELT fullband configs, from longest to shortest frame
+	stereo := w.lastTOC & 0x4
+	configs := []byte{31, 30, 29, 28}
+
+	for _, config := range configs {
+		frameSamples := opusConfigSamples[config]
+
+		for gapSamples >= frameSamples {
+			toc := config<<3 | stereo
+			if err := w.appendPacket([]byte{toc}, frameSamples); err != nil {
+				return err
+			}
+
+			gapSamples -= frameSamples
+			w.nGapSamples += frameSamples
+		}
+	}
+
+	return nil
+}
+
+func (w *oggWriter) appendPacket(payload []byte, packetSamples int) error {
+	if w.pending != nil {
+		if err := w.writePage(w.pending, w.granule, 0); err != nil {
+			return err
+		}
+	}
+
+	w.pending = append([]byte(nil), payload...)
+	w.granule += uint64(packetSamples)
+
+	return nil
+}
+
+func (w *oggWriter) writeHeaders() error {
+	head := make([]byte, 19)
+	copy(head[0:], "OpusHead")
+	head[8] = 1 // version
+	head[9] = byte(w.channels)
+	binary.LittleEndian.PutUint16(head[10:], oggPreSkip)
+	binary.LittleEndian.PutUint32(head[12:], opusGranuleRate)
+	binary.LittleEndian.PutUint16(head[16:], 0) // output gain
+	head[18] = 0                                // channel mapping family
+
+	if err := w.writePage(head, 0, oggFlagBOS); err != nil {
+		return err
+	}
+
+	const vendor = "webrtc-cli"
+
+	tags := make([]byte, 8+4+len(vendor)+4)
+	copy(tags[0:], "OpusTags")
+	binary.LittleEndian.PutUint32(tags[8:], uint32(len(vendor)))
+	copy(tags[12:], vendor)
+	binary.LittleEndian.PutUint32(tags[12+len(vendor):], 0) // comment count
+
+	return w.writePage(tags, 0, 0)
+}
+
+func (w *oggWriter) writePage(payload []byte, granule uint64, flags byte) error {
+	nSegments := len(payload)/255 + 1
+
+	page := make([]byte, oggPageHeaderLen+nSegments+len(payload))
+
+	copy(page[0:], "OggS")
+	page[4] = 0 // version
+	page[5] = flags
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], w.serial)
+	binary.LittleEndian.PutUint32(page[18:], w.pageSeq)
+	page[26] = byte(nSegments)
+
+	for i := 0; i < nSegments-1; i++ {
+		page[oggPageHeaderLen+i] = 255
+	}
+	page[oggPageHeaderLen+nSegments-1] = byte(len(payload) % 255)
+
+	copy(page[oggPageHeaderLen+nSegments:], payload)
+
+	binary.LittleEndian.PutUint32(page[22:], w.checksum(page))
+
+	w.pageSeq++
+
+	if _, err := w.fp.Write(page); err != nil {
+		return fmt.Errorf("can't write to ogg file: %s", err.Error())
+	}
+
+	return nil
+}
+
+// ogg uses non-reflected CRC-32 with 0x04c11db7 polynomial
+func (w *oggWriter) initCRC() {
+	const poly = 0x04c11db7
+
+	for i := range w.crcTable {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = (r << 1) ^ poly
+			} else {
+				r <<= 1
+			}
+		}
+		w.crcTable[i] = r
+	}
+}
+
+func (w *oggWriter) checksum(b []byte) uint32 {
+	var crc uint32
+	for _, v := range b {
+		crc = (crc << 8) ^ w.crcTable[byte(crc>>24)^v]
+	}
+	return crc
+}
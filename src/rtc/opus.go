@@ -1,9 +1,60 @@
 package rtc
 
+import (
+	"errors"
+)
+
 const (
 	// recommended opus packet size
 	maxFrameBytes = 4000
 
 	// maximum allowed opus packet duration
 	maxFrameMs = 120
+
+	// opus always uses 48kHz for timestamps in ogg container and
+	// in RTP (see RFC 7587), regardless of sample rate
+	opusGranuleRate = 48000
+
+	// PLC duration should be a multiple of 2.5ms
+	plcGranularityPerSec = 400
 )
+
+// frame durations in 48kHz samples, indexed by toc config
+var opusConfigSamples = [32]int{
+	// SILK: 10, 20, 40, 60 ms
+	480, 960, 1920, 2880, 480, 960, 1920, 2880, 480, 960, 1920, 2880,
+	// Hybrid: 10, 20 ms
+	480, 960, 480, 960,
+	// CELT: 2.5, 5, 10, 20 ms
+	120, 240, 480, 960, 120, 240, 480, 960,
+	120, 240, 480, 960, 120, 240, 480, 960,
+}
+
+// returns packet duration in 48kHz samples, see RFC 6716, section 3.1
+func opusPacketSamples(payload []byte) (int, error) {
+	if len(payload) == 0 {
+		return 0, errors.New("empty opus packet")
+	}
+
+	toc := payload[0]
+
+	var frameCount int
+	switch toc & 0x3 {
+	case 0:
+		frameCount = 1
+	case 1, 2:
+		frameCount = 2
+	case 3:
+		if len(payload) < 2 {
+			return 0, errors.New("truncated opus packet")
+		}
+		frameCount = int(payload[1] & 0x3f)
+	}
+
+	samples := frameCount * opusConfigSamples[toc>>3]
+	if samples > opusGranuleRate*maxFrameMs/1000 {
+		return 0, errors.New("opus packet too long")
+	}
+
+	return samples, nil
+}
@@ -4,15 +4,26 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
-	"os"
+	"net"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v2"
-	"github.com/pion/webrtc/v2/pkg/media"
+	"golang.org/x/time/rate"
 	"gopkg.in/gavv/opus.v2"
+
+	"github.com/gavv/webrtc-cli/src/log"
+	"github.com/gavv/webrtc-cli/src/netsim"
 )
 
+var peerLog = log.New("peer")
+
+// same as buffer size used by pion for received packets
+const receiveMTU = 8192
+
 type State string
 
 func (s State) String() string {
@@ -50,7 +61,20 @@ type Params struct {
 	Complexity  int
 	LossPercent int
 
-	SimulateLossPercent int
+	// network impairments applied to received and sent packets
+	ReceiveImpairment netsim.Params
+	SendImpairment    netsim.Params
+
+	// optional changes of impairments over time
+	ImpairmentScript netsim.Script
+
+	// how long to wait for late packets before decoding, zero to disable
+	ReorderWindow time.Duration
+
+	RecordOpus string
+
+	// write received RTP and RTCP packets to pcap or rtpdump file
+	CaptureRTP string
 
 	Debug bool
 }
@@ -61,15 +85,43 @@ type Peer struct {
 	offer  *webrtc.SessionDescription
 	answer *webrtc.SessionDescription
 
-	localTrack  *webrtc.Track
-	remoteTrack *webrtc.Track
+	localTrack     *webrtc.Track
+	localSender    *webrtc.RTPSender
+	remoteTrack    *webrtc.Track
+	remoteReceiver *webrtc.RTPReceiver
 
 	encoder      *opus.Encoder
 	decoder      *opus.Decoder
 	depacketizer *depacketizer
-
-	channels         int
-	simulateLossPerc int
+	decodeMu     sync.Mutex
+	reorder      *reorderBuffer
+	recorder     *oggWriter
+	capture      *captureWriter
+	rtcpCh       chan []byte
+
+	rate     int
+	channels int
+
+	// protects stats, which are updated by reading and writing goroutines
+	// and by RTCP goroutines
+	statsMu   sync.Mutex
+	sendStats sendStats
+	recvStats receiveStats
+	statsSSRC uint32
+	logRTCP   *rate.Limiter
+
+	readerOnce sync.Once
+	readerCh   chan rtpResult
+
+	recvSim     *netsim.Simulator
+	recvSimOnce sync.Once
+	recvErr     error
+	recvErrCh   chan struct{}
+
+	sendSim   *netsim.Simulator
+	sendErrCh chan error
+	sendSeq   uint16
+	sendTs    uint32
 
 	remoteTrackCh chan struct{}
 	connCh        chan State
@@ -77,14 +129,32 @@ type Peer struct {
 	closedCh      chan struct{}
 }
 
+type rtpResult struct {
+	pkt *rtp.Packet
+	err error
+}
+
 func NewPeer(params Params) (*Peer, error) {
 	p := &Peer{
-		channels:         params.Channels,
-		simulateLossPerc: params.SimulateLossPercent,
-		remoteTrackCh:    make(chan struct{}),
-		connCh:           make(chan State, 128),
-		closingCh:        make(chan struct{}),
-		closedCh:         make(chan struct{}),
+		rate:          params.Rate,
+		channels:      params.Channels,
+		sendStats:     sendStats{rate: opusGranuleRate},
+		recvStats:     receiveStats{rate: opusGranuleRate},
+		statsSSRC:     rand.Uint32(),
+		rtcpCh:        make(chan []byte, 128),
+		remoteTrackCh: make(chan struct{}),
+		readerCh:      make(chan rtpResult),
+		recvErrCh:     make(chan struct{}),
+		sendErrCh:     make(chan error, 1),
+		connCh:        make(chan State, 128),
+		closingCh:     make(chan struct{}),
+		closedCh:      make(chan struct{}),
+	}
+
+	if params.Debug || peerLog.Enabled(log.LevelDebug) {
+		p.logRTCP = rate.NewLimiter(rate.Limit(0.5), 1)
+	} else {
+		p.logRTCP = rate.NewLimiter(rate.Limit(0.01), 1)
 	}
 
 	var mediaEngine *webrtc.MediaEngine
@@ -107,20 +177,25 @@ func NewPeer(params Params) (*Peer, error) {
 
 	settingEngine := webrtc.SettingEngine{}
 	if params.MinPort != 0 || params.MaxPort != 0 {
-		fmt.Fprintf(os.Stderr, "Using UDP port range [%d; %d]\n",
-			params.MinPort, params.MaxPort)
+		peerLog.Info("port_range",
+			log.Fields{"min_port": params.MinPort, "max_port": params.MaxPort},
+			"Using UDP port range [%d; %d]", params.MinPort, params.MaxPort)
 		settingEngine.SetEphemeralUDPPortRange(params.MinPort, params.MaxPort)
 	}
 
 	api := webrtc.NewAPI(webrtc.WithMediaEngine(*mediaEngine),
 		webrtc.WithSettingEngine(settingEngine))
 
+	// without ICE server, only host candidates are used
+	var iceServers []webrtc.ICEServer
+	if params.IceURL != "" {
+		iceServers = append(iceServers, webrtc.ICEServer{
+			URLs: []string{params.IceURL},
+		})
+	}
+
 	p.conn, err = api.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{params.IceURL},
-			},
-		},
+		ICEServers: iceServers,
 	})
 	if err != nil {
 		return nil, err
@@ -133,10 +208,17 @@ func NewPeer(params Params) (*Peer, error) {
 			return nil, fmt.Errorf("can't create local track: %s", err.Error())
 		}
 
-		if _, err = p.conn.AddTrack(p.localTrack); err != nil {
+		p.localSender, err = p.conn.AddTrack(p.localTrack)
+		if err != nil {
 			return nil, fmt.Errorf("can't add local track to connection: %s", err.Error())
 		}
 
+		// track packetizer is not used, since we need to know timestamps
+		// of sent packets for sender reports
+		p.statsSSRC = p.localTrack.SSRC()
+		p.sendSeq = uint16(rand.Uint32())
+		p.sendTs = rand.Uint32()
+
 		p.encoder, err = opus.NewEncoder(
 			params.Rate, params.Channels, opus.Application(params.Mode))
 		if err != nil {
@@ -148,7 +230,7 @@ func NewPeer(params Params) (*Peer, error) {
 		}
 
 		if enableFEC {
-			fmt.Fprintln(os.Stderr, "Enabling in-band FEC")
+			peerLog.Info("fec_enabled", nil, "Enabling in-band FEC")
 
 			if err := p.encoder.SetPacketLossPerc(params.LossPercent); err != nil {
 				return nil, fmt.Errorf("can't set packet loss percent: %s", err.Error())
@@ -160,17 +242,40 @@ func NewPeer(params Params) (*Peer, error) {
 	}
 
 	if params.EnableRead {
-		if _, err = p.conn.AddTransceiver(webrtc.RTPCodecTypeAudio); err != nil {
+		transceiver, err := p.conn.AddTransceiver(webrtc.RTPCodecTypeAudio)
+		if err != nil {
 			return nil, fmt.Errorf("can't add transceiver: %s", err.Error())
 		}
 
+		if params.CaptureRTP != "" {
+			p.capture, err = newCaptureWriter(params.CaptureRTP)
+			if err != nil {
+				return nil, err
+			}
+
+			// all tracks share the same transport
+			transceiver.Receiver.Transport().ICETransport().OnSelectedCandidatePairChange(
+				func(pair *webrtc.ICECandidatePair) {
+					p.capture.setAddresses(
+						candidateAddr(pair.Local), candidateAddr(pair.Remote))
+				})
+		}
+
 		p.conn.OnTrack(func(track *webrtc.Track, receiver *webrtc.RTPReceiver) {
 			if p.remoteTrack == nil {
-				fmt.Fprintln(os.Stderr, "Accepting remote track")
+				peerLog.Info("track_accepted", log.Fields{
+					"ssrc":         track.SSRC(),
+					"payload_type": track.PayloadType(),
+					"codec":        track.Codec().Name,
+				}, "Accepting remote track")
 				p.remoteTrack = track
+				p.remoteReceiver = receiver
 				close(p.remoteTrackCh)
+
+				go p.runRTCPReader()
 			} else {
-				fmt.Fprintln(os.Stderr, "Ignoring remote track")
+				peerLog.Warn("track_ignored", log.Fields{"ssrc": track.SSRC()},
+					"Ignoring remote track")
 			}
 		})
 
@@ -181,6 +286,17 @@ func NewPeer(params Params) (*Peer, error) {
 
 		p.depacketizer = newDepacketizer(
 			p.decoder, enableFEC, params.Rate, params.Channels, params.Debug)
+
+		if params.ReorderWindow > 0 {
+			p.reorder = newReorderBuffer(params.ReorderWindow, params.Debug)
+		}
+
+		if params.RecordOpus != "" {
+			p.recorder, err = newOggWriter(params.RecordOpus, params.Channels)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	p.conn.OnICEConnectionStateChange(
@@ -236,20 +352,108 @@ func NewPeer(params Params) (*Peer, error) {
 		}
 	}
 
+	if params.EnableWrite &&
+		(params.SendImpairment.Enabled() || len(params.ImpairmentScript.Send) != 0) {
+		p.sendSim = netsim.NewSimulator(
+			"send", params.SendImpairment, params.ImpairmentScript.Send)
+
+		go p.runImpairedWriter()
+	}
+
+	if params.EnableRead &&
+		(params.ReceiveImpairment.Enabled() || len(params.ImpairmentScript.Receive) != 0) {
+		p.recvSim = netsim.NewSimulator(
+			"receive", params.ReceiveImpairment, params.ImpairmentScript.Receive)
+	}
+
+	if p.localSender != nil {
+		go p.runReportReader()
+	}
+
+	go p.runReportWriter()
+
 	return p, nil
 }
 
+// files are closed even if connection can't be closed, all errors are reported
 func (p *Peer) Close() error {
 	close(p.closingCh)
 
+	var errs []string
+
 	if err := p.conn.Close(); err != nil {
-		return err
+		errs = append(errs, err.Error())
+	} else {
+		<-p.closedCh
+	}
+
+	if p.sendSim != nil {
+		p.sendSim.Close()
+	}
+	if p.recvSim != nil {
+		p.recvSim.Close()
+	}
+
+	if p.recorder != nil {
+		if err := p.recorder.close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if p.capture != nil {
+		if err := p.capture.close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
 	}
 
-	<-p.closedCh
 	return nil
 }
 
+// returns call statistics gathered from sent and received RTP and RTCP
+func (p *Peer) Stats() Stats {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+
+	return Stats{
+		Send:    p.sendStats.stats(),
+		Receive: p.recvStats.stats(),
+		RTT:     p.sendStats.rtt,
+	}
+}
+
+// returns statistics of decoding, zero if reading is not enabled
+func (p *Peer) DecoderStats() DecoderStats {
+	if p.depacketizer == nil {
+		return DecoderStats{}
+	}
+
+	p.decodeMu.Lock()
+	defer p.decodeMu.Unlock()
+
+	return p.depacketizer.stats
+}
+
+// returns current ICE connection state
+func (p *Peer) CurrentState() State {
+	return State(p.conn.ICEConnectionState().String())
+}
+
+// returns summaries of simulated network impairments, if any
+func (p *Peer) ImpairmentSummary() []string {
+	var ret []string
+	if p.recvSim != nil {
+		ret = append(ret, p.recvSim.Summary())
+	}
+	if p.sendSim != nil {
+		ret = append(ret, p.sendSim.Summary())
+	}
+	return ret
+}
+
 func (p *Peer) State() <-chan State {
 	return p.connCh
 }
@@ -292,41 +496,418 @@ func (p *Peer) Write(pcm []int16) error {
 		return fmt.Errorf("can't encode opus frame: %s", err.Error())
 	}
 
-	err = p.localTrack.WriteSample(media.Sample{
-		Data:    b[:n],
-		Samples: uint32(len(pcm) / p.channels),
-	})
-	if err != nil {
-		return fmt.Errorf("can't send frame: %s", err.Error())
+	pkt := &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    p.localTrack.PayloadType(),
+			SequenceNumber: p.sendSeq,
+			Timestamp:      p.sendTs,
+			SSRC:           p.localTrack.SSRC(),
+		},
+		Payload: b[:n],
+	}
+
+	p.sendSeq++
+	p.sendTs += uint32(int64(len(pcm)/p.channels) * opusGranuleRate / int64(p.rate))
+
+	return p.send(pkt)
+}
+
+// sends already encoded opus packet, e.g. received from elsewhere
+func (p *Peer) WriteRTP(pkt *rtp.Packet) error {
+	if p.localTrack == nil {
+		panic("writing not enabled for peer")
+	}
+
+	// remote peer expects ssrc and payload type negotiated for local track,
+	// and doesn't know about any extensions of original packet
+	newPacket := &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         pkt.Marker,
+			PayloadType:    p.localTrack.PayloadType(),
+			SequenceNumber: pkt.SequenceNumber,
+			Timestamp:      pkt.Timestamp,
+			SSRC:           p.localTrack.SSRC(),
+		},
+		Payload: pkt.Payload,
 	}
 
+	return p.send(newPacket)
+}
+
+func (p *Peer) send(pkt *rtp.Packet) error {
+	// packets dropped by send simulator are counted as sent,
+	// like packets lost in network
+	p.statsMu.Lock()
+	p.sendStats.update(pkt, time.Now())
+	p.statsMu.Unlock()
+
+	if p.sendSim != nil {
+		return p.sendImpaired(pkt)
+	}
+
+	if err := p.localTrack.WriteRTP(pkt); err != nil {
+		return fmt.Errorf("can't send packet: %s", err.Error())
+	}
+
+	return nil
+}
+
+// passes packet to send simulator, packets are sent when they leave it
+func (p *Peer) sendImpaired(pkt *rtp.Packet) error {
+	select {
+	case err := <-p.sendErrCh:
+		return err
+	default:
+	}
+
+	p.sendSim.Push(pkt)
+
 	return nil
 }
 
+func (p *Peer) runImpairedWriter() {
+	for {
+		select {
+		case pkt := <-p.sendSim.Packets():
+			if err := p.localTrack.WriteRTP(pkt); err != nil {
+				select {
+				case p.sendErrCh <- fmt.Errorf("can't send packet: %s", err.Error()):
+				default:
+				}
+			}
+
+		case <-p.closingCh:
+			return
+		}
+	}
+}
+
 func (p *Peer) Read() ([]int16, error) {
+	buf, _, err := p.ReadWithTimestamp()
+	return buf, err
+}
+
+// also returns RTP timestamp of the packet from which samples were decoded;
+// opus RTP timestamps use 48kHz clock regardless of sample rate, see RFC 7587
+func (p *Peer) ReadWithTimestamp() ([]int16, uint32, error) {
 	for {
-		newPacket, err := p.getPacket()
+		newPacket, err := p.readOrdered()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		p.decodeMu.Lock()
+		buf, err := p.depacketizer.getSamples(newPacket)
+		p.decodeMu.Unlock()
+
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
-		if rand.Intn(100) < p.simulateLossPerc {
+		if len(buf) == 0 {
 			continue
 		}
 
-		buf, err := p.depacketizer.getSamples(newPacket)
+		return buf, newPacket.Timestamp, nil
+	}
+}
+
+// returns samples generated using PLC, to be played when no packets arrived
+// in time; can be called concurrently with Read, returns exactly requested
+// number of samples or nil if nothing can be concealed yet
+func (p *Peer) Conceal(numSamples int) []int16 {
+	if p.depacketizer == nil {
+		panic("reading not enabled for peer")
+	}
+
+	p.decodeMu.Lock()
+	defer p.decodeMu.Unlock()
+
+	return p.depacketizer.conceal(numSamples)
+}
+
+// returns samples generated using PLC, to be played before samples that
+// were already read, e.g. while buffer is refilled; unlike Conceal, doesn't
+// skip samples of late packets
+func (p *Peer) Extrapolate(numSamples int) []int16 {
+	if p.depacketizer == nil {
+		panic("reading not enabled for peer")
+	}
+
+	p.decodeMu.Lock()
+	defer p.decodeMu.Unlock()
+
+	return p.depacketizer.extrapolate(numSamples)
+}
+
+// returns packets in sequence number order, if reordering is enabled
+func (p *Peer) readOrdered() (*rtp.Packet, error) {
+	if p.reorder == nil {
+		return p.ReadRTP()
+	}
+
+	// packets are read in background, so that we can stop waiting
+	// for late packets even when no new packets arrive
+	p.readerOnce.Do(func() {
+		go p.runReader()
+	})
+
+	for {
+		if pkt := p.reorder.pop(time.Now()); pkt != nil {
+			return pkt, nil
+		}
+
+		var timer *time.Timer
+		var timeoutCh <-chan time.Time
+		if deadline, ok := p.reorder.deadline(); ok {
+			timer = time.NewTimer(time.Until(deadline))
+			timeoutCh = timer.C
+		}
+
+		var res rtpResult
+		select {
+		case res = <-p.readerCh:
+		case <-timeoutCh:
+		case <-p.closingCh:
+			res.err = errors.New("peer is closed")
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+
+		if res.err != nil {
+			return nil, res.err
+		}
+		if res.pkt != nil {
+			p.reorder.push(res.pkt, time.Now())
+		}
+	}
+}
+
+func (p *Peer) runReader() {
+	for {
+		pkt, err := p.ReadRTP()
+
+		select {
+		case p.readerCh <- rtpResult{pkt: pkt, err: err}:
+		case <-p.closingCh:
+			return
+		}
+
 		if err != nil {
-			return nil, err
+			return
 		}
+	}
+}
 
-		if len(buf) == 0 {
+// returns received packet without decoding
+func (p *Peer) ReadRTP() (*rtp.Packet, error) {
+	pkt, err := p.readImpaired()
+	if err != nil {
+		return nil, err
+	}
+
+	// packets dropped by receive simulator are counted as lost
+	p.statsMu.Lock()
+	p.recvStats.update(pkt, time.Now())
+	p.statsMu.Unlock()
+
+	return pkt, nil
+}
+
+func (p *Peer) readImpaired() (*rtp.Packet, error) {
+	if p.recvSim == nil {
+		return p.getPacket()
+	}
+
+	p.recvSimOnce.Do(func() {
+		go p.runImpairedReader()
+	})
+
+	select {
+	case pkt := <-p.recvSim.Packets():
+		return pkt, nil
+	case <-p.recvErrCh:
+		return nil, p.recvErr
+	case <-p.closingCh:
+		return nil, errors.New("peer is closed")
+	}
+}
+
+// passes received packets through receive simulator
+func (p *Peer) runImpairedReader() {
+	for {
+		pkt, err := p.getPacket()
+		if err != nil {
+			p.recvErr = err
+			close(p.recvErrCh)
+			return
+		}
+
+		p.recvSim.Push(pkt)
+	}
+}
+
+// returns RTCP packets received for remote track
+func (p *Peer) ReadRTCP() ([]rtcp.Packet, error) {
+	select {
+	case <-p.remoteTrackCh:
+	case <-p.closingCh:
+		return nil, errors.New("peer is closed")
+	}
+
+	// packets are read in background to gather stats and capture them
+	// even if nobody reads them
+	select {
+	case b := <-p.rtcpCh:
+		pkts, err := rtcp.Unmarshal(b)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse RTCP packet: %s", err.Error())
+		}
+		return pkts, nil
+	case <-p.closingCh:
+		return nil, errors.New("peer is closed")
+	}
+}
+
+func (p *Peer) runRTCPReader() {
+	for {
+		b := make([]byte, receiveMTU)
+
+		n, err := p.remoteReceiver.Read(b)
+		if err != nil {
+			return
+		}
+
+		now := time.Now()
+
+		if p.capture != nil {
+			if err := p.capture.writeRTCP(b[:n], now); err != nil {
+				peerLog.Warn("capture_failed", log.Fields{"error": err.Error()},
+					"Capturing stopped: %s", err.Error())
+				p.capture.close()
+			}
+		}
+
+		if pkts, err := rtcp.Unmarshal(b[:n]); err == nil {
+			p.statsMu.Lock()
+			for _, pkt := range pkts {
+				if sr, ok := pkt.(*rtcp.SenderReport); ok {
+					p.recvStats.updateSenderReport(sr, now)
+				}
+			}
+			p.statsMu.Unlock()
+		}
+
+		select {
+		case p.rtcpCh <- b[:n]:
+		default:
+			// nobody reads RTCP
+		}
+	}
+}
+
+// reads reception reports of remote peer about local track
+func (p *Peer) runReportReader() {
+	for {
+		pkts, err := p.localSender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		now := time.Now()
+
+		p.statsMu.Lock()
+		for _, pkt := range pkts {
+			var reports []rtcp.ReceptionReport
+
+			switch r := pkt.(type) {
+			case *rtcp.ReceiverReport:
+				reports = r.Reports
+			case *rtcp.SenderReport:
+				reports = r.Reports
+			}
+
+			for _, report := range reports {
+				if report.SSRC == p.localTrack.SSRC() {
+					p.sendStats.updateReport(report, now)
+				}
+			}
+		}
+		p.statsMu.Unlock()
+	}
+}
+
+// periodically sends sender and receiver reports, since pion doesn't
+func (p *Peer) runReportWriter() {
+	ticker := time.NewTicker(rtcpInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.closingCh:
+			return
+		}
+
+		pkts := p.buildReports(time.Now())
+		if len(pkts) == 0 {
 			continue
 		}
 
-		return buf, nil
+		if err := p.conn.WriteRTCP(pkts); err != nil {
+			if p.logRTCP.Allow() {
+				peerLog.Debug("rtcp_send_failed", log.Fields{"error": err.Error()},
+					"Can't send RTCP report: %s", err.Error())
+			}
+		}
 	}
 }
 
+func (p *Peer) buildReports(now time.Time) []rtcp.Packet {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+
+	p.sendStats.updateBitrate(now)
+	p.recvStats.updateBitrate(now)
+
+	var reports []rtcp.ReceptionReport
+	if r, ok := p.recvStats.receptionReport(now); ok {
+		reports = append(reports, r)
+	}
+
+	if sr, ok := p.sendStats.senderReport(p.statsSSRC, now); ok {
+		sr.Reports = reports
+
+		// pion delivers RTCP packets to streams listed in packet, and sender
+		// report lists only streams it reports about; so we add SDES with the
+		// same CNAME as in SDP, which lists our stream
+		sdes := &rtcp.SourceDescription{
+			Chunks: []rtcp.SourceDescriptionChunk{{
+				Source: p.statsSSRC,
+				Items: []rtcp.SourceDescriptionItem{{
+					Type: rtcp.SDESCNAME,
+					Text: p.localTrack.Label(),
+				}},
+			}},
+		}
+
+		return []rtcp.Packet{sr, sdes}
+	}
+
+	if len(reports) != 0 {
+		return []rtcp.Packet{&rtcp.ReceiverReport{
+			SSRC:    p.statsSSRC,
+			Reports: reports,
+		}}
+	}
+
+	return nil
+}
+
 func (p *Peer) getPacket() (*rtp.Packet, error) {
 	select {
 	case <-p.remoteTrackCh:
@@ -334,10 +915,46 @@ func (p *Peer) getPacket() (*rtp.Packet, error) {
 		return nil, errors.New("peer is closed")
 	}
 
-	pkt, err := p.remoteTrack.ReadRTP()
+	b := make([]byte, receiveMTU)
+
+	n, err := p.remoteTrack.Read(b)
 	if err != nil {
 		return nil, fmt.Errorf("can't read RTP packet: %s", err.Error())
 	}
 
+	// capture and recording errors don't interrupt playback
+	if p.capture != nil {
+		if err := p.capture.writeRTP(b[:n], time.Now()); err != nil {
+			peerLog.Warn("capture_failed", log.Fields{"error": err.Error()},
+				"Capturing stopped: %s", err.Error())
+			p.capture.close()
+		}
+	}
+
+	pkt := &rtp.Packet{}
+	if err := pkt.Unmarshal(b[:n]); err != nil {
+		return nil, fmt.Errorf("can't parse RTP packet: %s", err.Error())
+	}
+
+	if p.recorder != nil {
+		if err := p.recorder.writePacket(pkt); err != nil {
+			recordLog.Warn("recording_failed", log.Fields{"error": err.Error()},
+				"Recording stopped: %s", err.Error())
+			p.recorder.close()
+		}
+	}
+
 	return pkt, nil
 }
+
+func candidateAddr(c *webrtc.ICECandidate) *net.UDPAddr {
+	addr := &net.UDPAddr{IP: net.IPv4zero}
+	if c == nil {
+		return addr
+	}
+	if ip := net.ParseIP(c.Address); ip != nil {
+		addr.IP = ip
+	}
+	addr.Port = int(c.Port)
+	return addr
+}
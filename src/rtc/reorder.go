@@ -0,0 +1,151 @@
+package rtc
+
+import (
+	"time"
+
+	"github.com/pion/rtp"
+	"golang.org/x/time/rate"
+
+	"github.com/gavv/webrtc-cli/src/log"
+)
+
+var reorderLog = log.New("reorder")
+
+// larger sequence number jumps mean that stream was restarted
+const maxReorderGap = 100
+
+type reorderEntry struct {
+	pkt     *rtp.Packet
+	arrival time.Time
+}
+
+// holds packets arrived ahead of their predecessors for a while, so that late
+// packets can be decoded in order instead of being recovered using FEC or PLC
+type reorderBuffer struct {
+	window time.Duration
+
+	// sorted by sequence number
+	entries []reorderEntry
+
+	nextSeq uint16
+	started bool
+
+	logLim *rate.Limiter
+
+	nReordered int
+	nLost      int
+	nLate      int
+}
+
+func newReorderBuffer(window time.Duration, debug bool) *reorderBuffer {
+	b := &reorderBuffer{
+		window: window,
+	}
+
+	if debug || reorderLog.Enabled(log.LevelDebug) {
+		b.logLim = rate.NewLimiter(rate.Limit(0.5), 1)
+	} else {
+		b.logLim = rate.NewLimiter(rate.Limit(0.01), 1)
+	}
+
+	return b
+}
+
+func (b *reorderBuffer) push(pkt *rtp.Packet, now time.Time) {
+	if !b.started {
+		b.nextSeq = pkt.SequenceNumber
+		b.started = true
+	}
+
+	diff := int16(pkt.SequenceNumber - b.nextSeq)
+
+	if diff < -maxReorderGap || diff > maxReorderGap {
+		b.restart(pkt.SequenceNumber)
+		diff = 0
+	}
+
+	// already released packets with larger sequence numbers,
+	// this one was counted as lost
+	if diff < 0 {
+		b.nLate++
+		b.report()
+		return
+	}
+
+	pos := len(b.entries)
+	for n, e := range b.entries {
+		entryDiff := int16(e.pkt.SequenceNumber - pkt.SequenceNumber)
+		if entryDiff == 0 {
+			// duplicate
+			return
+		}
+		if entryDiff > 0 {
+			pos = n
+			break
+		}
+	}
+
+	// arrived after its successor
+	if pos < len(b.entries) {
+		b.nReordered++
+		b.report()
+	}
+
+	b.entries = append(b.entries, reorderEntry{})
+	copy(b.entries[pos+1:], b.entries[pos:])
+	b.entries[pos] = reorderEntry{pkt: pkt, arrival: now}
+}
+
+// returns next packet if it's in order or if we can't wait for missing ones anymore
+func (b *reorderBuffer) pop(now time.Time) *rtp.Packet {
+	if len(b.entries) == 0 {
+		return nil
+	}
+
+	head := b.entries[0]
+
+	if head.pkt.SequenceNumber != b.nextSeq {
+		if now.Sub(head.arrival) < b.window {
+			return nil
+		}
+
+		b.nLost += int(head.pkt.SequenceNumber - b.nextSeq)
+		b.report()
+	}
+
+	b.entries = b.entries[1:]
+	b.nextSeq = head.pkt.SequenceNumber + 1
+
+	return head.pkt
+}
+
+// returns time when next packet should be released even if there is a gap
+func (b *reorderBuffer) deadline() (time.Time, bool) {
+	if len(b.entries) == 0 {
+		return time.Time{}, false
+	}
+	return b.entries[0].arrival.Add(b.window), true
+}
+
+func (b *reorderBuffer) restart(seq uint16) {
+	reorderLog.Warn("sequence_jump", log.Fields{"from": b.nextSeq, "to": seq},
+		"Sequence number jumped from %d to %d, restarting reordering", b.nextSeq, seq)
+
+	// held packets belong to old stream and can't be ordered with new ones
+	b.nLost += len(b.entries)
+	b.entries = nil
+
+	b.nextSeq = seq
+}
+
+func (b *reorderBuffer) report() {
+	if b.logLim.Allow() {
+		reorderLog.Debug("reorder_stats", log.Fields{
+			"reordered":    b.nReordered,
+			"lost":         b.nLost,
+			"late_dropped": b.nLate,
+		}, "Reordered %d packets, lost %d packets, dropped %d too late packets",
+			b.nReordered, b.nLost, b.nLate)
+		b.nReordered, b.nLost, b.nLate = 0, 0, 0
+	}
+}
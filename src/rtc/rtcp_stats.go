@@ -0,0 +1,323 @@
+package rtc
+
+import (
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// interval between RTCP reports sent by peer
+const rtcpInterval = time.Second
+
+// seconds between 1900 and 1970
+const ntpEpochOffset = 2208988800
+
+// statistics of one direction of the call
+type StreamStats struct {
+	// RTP packets and payload bytes sent or received
+	Packets uint64
+	Bytes   uint64
+
+	// payload bitrate during last RTCP interval, bits per second
+	Bitrate float64
+
+	// duration of audio in one packet, measured from timestamps
+	FrameLength time.Duration
+
+	// for sent stream, whether remote peer sent reception reports
+	Reported bool
+
+	// fraction of packets lost during last RTCP interval, total number
+	// of lost packets, and interarrival jitter; for sent stream, these
+	// are taken from reports of remote peer
+	FractionLost   float64
+	CumulativeLost int
+	Jitter         time.Duration
+}
+
+type Stats struct {
+	Send    StreamStats
+	Receive StreamStats
+
+	// round-trip time, zero if remote peer didn't report it yet
+	RTT time.Duration
+}
+
+// tracks sent stream and produces sender reports
+type sendStats struct {
+	rate int
+
+	packets uint64
+	bytes   uint64
+
+	// last sent packet and its wall clock time
+	lastSeq       uint16
+	lastTimestamp uint32
+	lastTime      time.Time
+
+	// in timestamp units
+	frameLength uint32
+
+	bitrate      float64
+	bitrateBytes uint64
+	bitrateTime  time.Time
+
+	// from reception reports of remote peer
+	reported       bool
+	fractionLost   float64
+	cumulativeLost int
+	jitter         time.Duration
+	rtt            time.Duration
+}
+
+func (s *sendStats) update(pkt *rtp.Packet, now time.Time) {
+	if s.packets != 0 && pkt.SequenceNumber == s.lastSeq+1 {
+		s.frameLength = pkt.Timestamp - s.lastTimestamp
+	}
+
+	s.packets++
+	s.bytes += uint64(len(pkt.Payload))
+
+	s.lastSeq = pkt.SequenceNumber
+	s.lastTimestamp = pkt.Timestamp
+	s.lastTime = now
+}
+
+func (s *sendStats) senderReport(ssrc uint32, now time.Time) (*rtcp.SenderReport, bool) {
+	if s.packets == 0 {
+		return nil, false
+	}
+
+	// timestamp that would be sent now
+	elapsed := now.Sub(s.lastTime)
+	timestamp := s.lastTimestamp + uint32(elapsed.Seconds()*float64(s.rate))
+
+	return &rtcp.SenderReport{
+		SSRC:        ssrc,
+		NTPTime:     toNTP(now),
+		RTPTime:     timestamp,
+		PacketCount: uint32(s.packets),
+		OctetCount:  uint32(s.bytes),
+	}, true
+}
+
+// handles reception report of remote peer about our stream
+func (s *sendStats) updateReport(r rtcp.ReceptionReport, now time.Time) {
+	s.reported = true
+	s.fractionLost = float64(r.FractionLost) / 256
+	s.cumulativeLost = int(int32(r.TotalLost<<8) >> 8)
+	s.jitter = time.Duration(float64(r.Jitter) / float64(s.rate) * float64(time.Second))
+
+	if r.LastSenderReport != 0 {
+		// in 1/65536 of second
+		rtt := ntpMiddle(toNTP(now)) - r.LastSenderReport - r.Delay
+		if int32(rtt) >= 0 {
+			s.rtt = time.Duration(uint64(rtt) * uint64(time.Second) / 65536)
+		}
+	}
+}
+
+func (s *sendStats) updateBitrate(now time.Time) {
+	s.bitrate, s.bitrateBytes, s.bitrateTime =
+		computeBitrate(s.bytes, s.bitrateBytes, now, s.bitrateTime)
+}
+
+func (s *sendStats) stats() StreamStats {
+	return StreamStats{
+		Packets:        s.packets,
+		Bytes:          s.bytes,
+		Bitrate:        s.bitrate,
+		FrameLength:    timestampsToDuration(s.frameLength, s.rate),
+		Reported:       s.reported,
+		FractionLost:   s.fractionLost,
+		CumulativeLost: s.cumulativeLost,
+		Jitter:         s.jitter,
+	}
+}
+
+// tracks received stream and produces reception reports, see RFC 3550 appendix A
+type receiveStats struct {
+	rate int
+
+	started bool
+	ssrc    uint32
+
+	baseSeq uint16
+	maxSeq  uint16
+	cycles  uint32
+
+	// last received packet and duration of audio in it, in timestamp units
+	lastSeq       uint16
+	lastTimestamp uint32
+	frameLength   uint32
+
+	packets uint64
+	bytes   uint64
+
+	expectedPrior uint64
+	receivedPrior uint64
+	fractionLost  float64
+
+	// in timestamp units
+	jitter      float64
+	lastTransit float64
+
+	// middle bits of NTP time of last sender report and its arrival time
+	lastSR     uint32
+	lastSRTime time.Time
+
+	bitrate      float64
+	bitrateBytes uint64
+	bitrateTime  time.Time
+}
+
+func (s *receiveStats) update(pkt *rtp.Packet, arrival time.Time) {
+	transit := float64(arrival.UnixNano())/float64(time.Second)*float64(s.rate) -
+		float64(pkt.Timestamp)
+
+	if !s.started || pkt.SSRC != s.ssrc {
+		*s = receiveStats{
+			rate:        s.rate,
+			started:     true,
+			ssrc:        pkt.SSRC,
+			baseSeq:     pkt.SequenceNumber,
+			maxSeq:      pkt.SequenceNumber,
+			lastTransit: transit,
+		}
+	}
+
+	if s.packets != 0 && pkt.SequenceNumber == s.lastSeq+1 {
+		s.frameLength = pkt.Timestamp - s.lastTimestamp
+	}
+	s.lastSeq = pkt.SequenceNumber
+	s.lastTimestamp = pkt.Timestamp
+
+	s.packets++
+	s.bytes += uint64(len(pkt.Payload))
+
+	if diff := int16(pkt.SequenceNumber - s.maxSeq); diff > 0 {
+		if pkt.SequenceNumber < s.maxSeq {
+			s.cycles += 1 << 16
+		}
+		s.maxSeq = pkt.SequenceNumber
+	}
+
+	// timestamps are 32-bit and wrap around
+	d := transit - s.lastTransit
+	d -= float64(int64(d)/(1<<32)) * (1 << 32)
+	if d < 0 {
+		d = -d
+	}
+	if d < 1<<31 {
+		s.jitter += (d - s.jitter) / 16
+	}
+	s.lastTransit = transit
+}
+
+func (s *receiveStats) updateSenderReport(sr *rtcp.SenderReport, arrival time.Time) {
+	if sr.SSRC != s.ssrc {
+		return
+	}
+
+	s.lastSR = ntpMiddle(sr.NTPTime)
+	s.lastSRTime = arrival
+}
+
+func (s *receiveStats) expected() uint64 {
+	return uint64(s.cycles) + uint64(s.maxSeq) - uint64(s.baseSeq) + 1
+}
+
+func (s *receiveStats) lost() int {
+	if !s.started {
+		return 0
+	}
+	return int(int64(s.expected()) - int64(s.packets))
+}
+
+// returns report block and starts new interval
+func (s *receiveStats) receptionReport(now time.Time) (rtcp.ReceptionReport, bool) {
+	if !s.started {
+		return rtcp.ReceptionReport{}, false
+	}
+
+	expected := s.expected()
+
+	expectedInterval := int64(expected - s.expectedPrior)
+	receivedInterval := int64(s.packets - s.receivedPrior)
+	lostInterval := expectedInterval - receivedInterval
+
+	s.expectedPrior = expected
+	s.receivedPrior = s.packets
+
+	s.fractionLost = 0
+	if expectedInterval > 0 && lostInterval > 0 {
+		s.fractionLost = float64(lostInterval) / float64(expectedInterval)
+	}
+
+	// signed 24-bit
+	lost := s.lost()
+	if lost > 0x7fffff {
+		lost = 0x7fffff
+	} else if lost < -0x800000 {
+		lost = -0x800000
+	}
+
+	r := rtcp.ReceptionReport{
+		SSRC:               s.ssrc,
+		FractionLost:       uint8(s.fractionLost * 256),
+		TotalLost:          uint32(lost) & 0xffffff,
+		LastSequenceNumber: s.cycles + uint32(s.maxSeq),
+		Jitter:             uint32(s.jitter),
+	}
+
+	if !s.lastSRTime.IsZero() {
+		r.LastSenderReport = s.lastSR
+		r.Delay = uint32(now.Sub(s.lastSRTime).Seconds() * 65536)
+	}
+
+	return r, true
+}
+
+func (s *receiveStats) updateBitrate(now time.Time) {
+	s.bitrate, s.bitrateBytes, s.bitrateTime =
+		computeBitrate(s.bytes, s.bitrateBytes, now, s.bitrateTime)
+}
+
+func (s *receiveStats) stats() StreamStats {
+	return StreamStats{
+		Packets:        s.packets,
+		Bytes:          s.bytes,
+		Bitrate:        s.bitrate,
+		FrameLength:    timestampsToDuration(s.frameLength, s.rate),
+		FractionLost:   s.fractionLost,
+		CumulativeLost: s.lost(),
+		Jitter:         time.Duration(s.jitter / float64(s.rate) * float64(time.Second)),
+	}
+}
+
+// returns bitrate since previous call and values for the next call
+func computeBitrate(
+	bytes, prevBytes uint64, now, prevTime time.Time,
+) (float64, uint64, time.Time) {
+	if prevTime.IsZero() || !now.After(prevTime) {
+		return 0, bytes, now
+	}
+	bitrate := float64(bytes-prevBytes) * 8 / now.Sub(prevTime).Seconds()
+	return bitrate, bytes, now
+}
+
+func timestampsToDuration(ts uint32, rate int) time.Duration {
+	return time.Duration(uint64(ts) * uint64(time.Second) / uint64(rate))
+}
+
+func toNTP(t time.Time) uint64 {
+	sec := uint64(t.Unix()) + ntpEpochOffset
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return sec<<32 | frac
+}
+
+// middle 32 bits of NTP timestamp, used in reception reports
+func ntpMiddle(ntp uint64) uint32 {
+	return uint32(ntp >> 16)
+}
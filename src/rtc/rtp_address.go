@@ -0,0 +1,45 @@
+package rtc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const rtpPrefix = "rtp://"
+
+func IsRTPAddress(s string) bool {
+	return strings.HasPrefix(s, rtpPrefix)
+}
+
+// host and port from url take precedence over default ones
+func resolveRTPAddress(url, defaultHost, defaultPort string) (*net.UDPAddr, error) {
+	hostPort := strings.TrimPrefix(url, rtpPrefix)
+
+	host, port := hostPort, ""
+	if strings.Contains(hostPort, ":") {
+		var err error
+		host, port, err = net.SplitHostPort(hostPort)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RTP address %q: %s", url, err.Error())
+		}
+	}
+
+	if host == "" {
+		host = defaultHost
+	}
+	if port == "" || port == "0" {
+		port = defaultPort
+	}
+
+	if port == "" || port == "0" {
+		return nil, fmt.Errorf("invalid RTP address %q: port should be specified", url)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RTP address %q: %s", url, err.Error())
+	}
+
+	return addr, nil
+}
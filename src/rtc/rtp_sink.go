@@ -0,0 +1,296 @@
+package rtc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"golang.org/x/time/rate"
+
+	"github.com/gavv/webrtc-cli/src/log"
+)
+
+// used by RTP source and sink
+var rtpLog = log.New("rtp")
+
+type RTPSinkParams struct {
+	// e.g. "rtp://127.0.0.1:5004", RTCP is sent to port+1
+	URL string
+
+	// optional SDP file to be written when remote track is received
+	SDPFile string
+
+	// rewrite SSRC if non-zero
+	SSRC uint32
+
+	// rewrite payload type if non-negative
+	PayloadType int
+
+	Debug bool
+}
+
+// forwards remote track of the peer as plain RTP over UDP
+type RTPSink struct {
+	peer   *Peer
+	params RTPSinkParams
+
+	rtpAddr  *net.UDPAddr
+	rtcpAddr *net.UDPAddr
+
+	rtpConn  *net.UDPConn
+	rtcpConn *net.UDPConn
+
+	logErr *rate.Limiter
+
+	errCh     chan error
+	closingCh chan struct{}
+}
+
+func NewRTPSink(params RTPSinkParams, peer *Peer) (*RTPSink, error) {
+	if peer.depacketizer == nil {
+		panic("reading not enabled for peer")
+	}
+
+	addr, err := resolveRTPAddress(params.URL, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	if addr.IP == nil || addr.IP.IsUnspecified() {
+		return nil, fmt.Errorf("invalid RTP address %q: host should be specified",
+			params.URL)
+	}
+
+	freq := 0.01
+	if params.Debug || rtpLog.Enabled(log.LevelDebug) {
+		freq = 0.5
+	}
+
+	s := &RTPSink{
+		peer:    peer,
+		params:  params,
+		rtpAddr: addr,
+		rtcpAddr: &net.UDPAddr{
+			IP:   addr.IP,
+			Port: addr.Port + 1,
+			Zone: addr.Zone,
+		},
+		logErr:    rate.NewLimiter(rate.Limit(freq), 1),
+		errCh:     make(chan error, 2),
+		closingCh: make(chan struct{}),
+	}
+
+	if s.rtpConn, err = net.DialUDP("udp", nil, s.rtpAddr); err != nil {
+		return nil, fmt.Errorf("can't open RTP socket: %s", err.Error())
+	}
+
+	if s.rtcpConn, err = net.DialUDP("udp", nil, s.rtcpAddr); err != nil {
+		s.rtpConn.Close()
+		return nil, fmt.Errorf("can't open RTCP socket: %s", err.Error())
+	}
+
+	rtpLog.Info("rtp_sending",
+		log.Fields{"rtp_addr": s.rtpAddr.String(), "rtcp_addr": s.rtcpAddr.String()},
+		"Sending RTP packets to %s and RTCP packets to %s", s.rtpAddr, s.rtcpAddr)
+
+	rtpDoneCh := make(chan struct{})
+	rtcpDoneCh := make(chan struct{})
+
+	go s.runRTP(rtpDoneCh)
+	go s.runRTCP(rtcpDoneCh)
+
+	go func() {
+		<-rtpDoneCh
+		<-rtcpDoneCh
+		close(s.errCh)
+	}()
+
+	return s, nil
+}
+
+// reports fatal error and closes when forwarding stops
+func (s *RTPSink) Errors() <-chan error {
+	return s.errCh
+}
+
+// forwarding goroutines exit when peer is closed
+func (s *RTPSink) Close() error {
+	close(s.closingCh)
+
+	err1 := s.rtpConn.Close()
+	err2 := s.rtcpConn.Close()
+
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
+
+func (s *RTPSink) runRTP(doneCh chan struct{}) {
+	defer close(doneCh)
+
+	for first := true; ; first = false {
+		pkt, err := s.peer.ReadRTP()
+		if err != nil {
+			s.reportError(err)
+			return
+		}
+
+		if first && s.params.SDPFile != "" {
+			if err := s.writeSDP(pkt); err != nil {
+				s.reportError(err)
+				return
+			}
+		}
+
+		newPacket := &rtp.Packet{
+			Header:  pkt.Header,
+			Payload: pkt.Payload,
+		}
+
+		if s.params.SSRC != 0 {
+			newPacket.SSRC = s.params.SSRC
+		}
+		if s.params.PayloadType >= 0 {
+			newPacket.PayloadType = uint8(s.params.PayloadType)
+		}
+
+		b, err := newPacket.Marshal()
+		if err != nil {
+			s.reportError(fmt.Errorf("can't marshal RTP packet: %s", err.Error()))
+			return
+		}
+
+		// receiver may be not started yet, don't treat it as fatal
+		if _, err := s.rtpConn.Write(b); err != nil && s.canLog() {
+			rtpLog.Warn("rtp_send_failed", log.Fields{"error": err.Error()},
+				"Can't send RTP packet: %s", err.Error())
+		}
+	}
+}
+
+func (s *RTPSink) runRTCP(doneCh chan struct{}) {
+	defer close(doneCh)
+
+	for {
+		pkts, err := s.peer.ReadRTCP()
+		if err != nil {
+			s.reportError(err)
+			return
+		}
+
+		if s.params.SSRC != 0 {
+			s.rewriteRTCP(pkts)
+		}
+
+		b, err := rtcp.Marshal(pkts)
+		if err != nil {
+			if s.canLog() {
+				rtpLog.Warn("rtcp_send_failed", log.Fields{"error": err.Error()},
+					"Can't marshal RTCP packet: %s", err.Error())
+			}
+			continue
+		}
+
+		if _, err := s.rtcpConn.Write(b); err != nil && s.canLog() {
+			rtpLog.Warn("rtcp_send_failed", log.Fields{"error": err.Error()},
+				"Can't send RTCP packet: %s", err.Error())
+		}
+	}
+}
+
+// replaces remote SSRC with configured one, so that RTCP matches RTP
+func (s *RTPSink) rewriteRTCP(pkts []rtcp.Packet) {
+	remoteSSRC := s.peer.remoteTrack.SSRC()
+
+	for _, pkt := range pkts {
+		switch p := pkt.(type) {
+		case *rtcp.SenderReport:
+			if p.SSRC == remoteSSRC {
+				p.SSRC = s.params.SSRC
+			}
+		case *rtcp.SourceDescription:
+			for n := range p.Chunks {
+				if p.Chunks[n].Source == remoteSSRC {
+					p.Chunks[n].Source = s.params.SSRC
+				}
+			}
+		case *rtcp.Goodbye:
+			for n := range p.Sources {
+				if p.Sources[n] == remoteSSRC {
+					p.Sources[n] = s.params.SSRC
+				}
+			}
+		}
+	}
+}
+
+// describes forwarded stream for players like ffplay or vlc
+func (s *RTPSink) writeSDP(pkt *rtp.Packet) error {
+	codec := s.peer.remoteTrack.Codec()
+
+	pt := int(pkt.PayloadType)
+	if s.params.PayloadType >= 0 {
+		pt = s.params.PayloadType
+	}
+
+	addrType := "IP4"
+	if s.rtpAddr.IP.To4() == nil {
+		addrType = "IP6"
+	}
+
+	rtpmap := fmt.Sprintf("%s/%d", codec.Name, codec.ClockRate)
+	if codec.Channels != 0 {
+		rtpmap += "/" + strconv.Itoa(int(codec.Channels))
+	}
+
+	lines := []string{
+		"v=0",
+		fmt.Sprintf("o=- 0 0 IN %s %s", addrType, s.rtpAddr.IP),
+		"s=webrtc-cli",
+		fmt.Sprintf("c=IN %s %s", addrType, s.rtpAddr.IP),
+		"t=0 0",
+		fmt.Sprintf("m=audio %d RTP/AVP %d", s.rtpAddr.Port, pt),
+		fmt.Sprintf("a=rtpmap:%d %s", pt, rtpmap),
+	}
+
+	if codec.SDPFmtpLine != "" {
+		lines = append(lines, fmt.Sprintf("a=fmtp:%d %s", pt, codec.SDPFmtpLine))
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("a=rtcp:%d", s.rtcpAddr.Port),
+		"a=recvonly")
+
+	text := strings.Join(lines, "\r\n") + "\r\n"
+
+	if err := ioutil.WriteFile(s.params.SDPFile, []byte(text), 0644); err != nil {
+		return fmt.Errorf("can't write sdp file: %s", err.Error())
+	}
+
+	rtpLog.Info("sdp_written", log.Fields{"path": s.params.SDPFile},
+		"Wrote SDP file to %s", s.params.SDPFile)
+
+	return nil
+}
+
+func (s *RTPSink) canLog() bool {
+	select {
+	case <-s.closingCh:
+		return false
+	default:
+		return s.logErr.Allow()
+	}
+}
+
+func (s *RTPSink) reportError(err error) {
+	select {
+	case <-s.closingCh:
+	default:
+		s.errCh <- err
+	}
+}
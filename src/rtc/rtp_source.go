@@ -0,0 +1,512 @@
+package rtc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v2"
+	"golang.org/x/time/rate"
+	"gopkg.in/gavv/opus.v2"
+
+	"github.com/gavv/webrtc-cli/src/dsp"
+	"github.com/gavv/webrtc-cli/src/log"
+)
+
+const (
+	// larger timestamp jumps in either direction are treated as stream restart
+	maxRTPGapMs = 1000
+
+	maxUDPPacketSize = 65536
+)
+
+type RTPSourceParams struct {
+	// e.g. "rtp://0.0.0.0:5004"
+	URL string
+
+	// optional SDP file with payload types and address
+	SDPFile string
+
+	// decode and re-encode opus instead of passing it through
+	Transcode bool
+
+	Rate        int
+	Channels    int
+	FrameLength time.Duration
+
+	Debug bool
+}
+
+type rtpPayload struct {
+	name      string
+	clockRate int
+	channels  int
+}
+
+func (p rtpPayload) String() string {
+	return fmt.Sprintf("%s/%d/%d", p.name, p.clockRate, p.channels)
+}
+
+// see RFC 3551, section 6
+var staticRTPPayloads = map[uint8]rtpPayload{
+	0:  {"PCMU", 8000, 1},
+	8:  {"PCMA", 8000, 1},
+	10: {"L16", 44100, 2},
+	11: {"L16", 44100, 1},
+}
+
+// receives plain RTP over UDP and writes it to the local track of the peer
+type RTPSource struct {
+	peer   *Peer
+	conn   *net.UDPConn
+	params RTPSourceParams
+
+	payloads map[uint8]rtpPayload
+
+	// current incoming stream
+	started       bool
+	ssrc          uint32
+	payloadType   uint8
+	payload       rtpPayload
+	nextTimestamp uint32
+
+	// outgoing sequence numbers and timestamps in passthrough mode
+	seqOffset     uint16
+	tsOffset      uint32
+	firstOutSeq   uint16
+	nextOutSeq    uint16
+	nextOutTstamp uint32
+
+	decoder   *opus.Decoder
+	resampler *dsp.Resampler
+	pending   []int16
+	frameSize int
+
+	logStats *rate.Limiter
+	logDrop  *rate.Limiter
+
+	nPackets    int
+	nLate       int
+	nGapSamples int
+
+	errCh     chan error
+	closingCh chan struct{}
+	doneCh    chan struct{}
+}
+
+func NewRTPSource(params RTPSourceParams, peer *Peer) (*RTPSource, error) {
+	if peer.localTrack == nil {
+		panic("writing not enabled for peer")
+	}
+
+	s := &RTPSource{
+		peer:          peer,
+		params:        params,
+		payloads:      make(map[uint8]rtpPayload),
+		nextOutSeq:    uint16(rand.Uint32()),
+		nextOutTstamp: rand.Uint32(),
+		frameSize: params.Rate * int(params.FrameLength/time.Millisecond) / 1000 *
+			params.Channels,
+		errCh:     make(chan error, 1),
+		closingCh: make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+
+	freq := 0.01
+	if params.Debug || rtpLog.Enabled(log.LevelDebug) {
+		freq = 0.5
+	}
+
+	s.logStats = rate.NewLimiter(rate.Limit(freq), 1)
+	s.logDrop = rate.NewLimiter(rate.Limit(freq), 1)
+
+	for pt, payload := range staticRTPPayloads {
+		s.payloads[pt] = payload
+	}
+
+	var sdpHost, sdpPort string
+
+	if params.SDPFile != "" {
+		var err error
+		sdpHost, sdpPort, err = s.readSDP(params.SDPFile)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// without SDP, assume that dynamic payload types are opus
+		for pt := 96; pt <= 127; pt++ {
+			s.payloads[uint8(pt)] = rtpPayload{"opus", opusGranuleRate, 2}
+		}
+	}
+
+	addr, err := resolveRTPAddress(params.URL, sdpHost, sdpPort)
+	if err != nil {
+		return nil, err
+	}
+
+	if addr.IP.IsMulticast() {
+		s.conn, err = net.ListenMulticastUDP("udp", nil, addr)
+	} else {
+		s.conn, err = net.ListenUDP("udp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't listen for RTP packets: %s", err.Error())
+	}
+
+	rtpLog.Info("rtp_receiving", log.Fields{"addr": addr.String()},
+		"Receiving RTP packets on %s", addr)
+
+	go s.runReceiving()
+
+	return s, nil
+}
+
+// reports fatal error and closes when receiving stops
+func (s *RTPSource) Errors() <-chan error {
+	return s.errCh
+}
+
+func (s *RTPSource) Close() error {
+	close(s.closingCh)
+
+	err := s.conn.Close()
+
+	<-s.doneCh
+
+	return err
+}
+
+func (s *RTPSource) runReceiving() {
+	defer close(s.doneCh)
+	defer close(s.errCh)
+
+	buf := make([]byte, maxUDPPacketSize)
+
+	for {
+		n, _, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.closingCh:
+			default:
+				s.errCh <- fmt.Errorf("can't receive RTP packet: %s", err.Error())
+			}
+			return
+		}
+
+		pkt := &rtp.Packet{}
+		if err := pkt.Unmarshal(append([]byte(nil), buf[:n]...)); err != nil {
+			s.logf("Ignoring malformed RTP packet: %s", err.Error())
+			continue
+		}
+
+		if err := s.handlePacket(pkt); err != nil {
+			s.errCh <- err
+			return
+		}
+	}
+}
+
+func (s *RTPSource) handlePacket(pkt *rtp.Packet) error {
+	payload, ok := s.payloads[pkt.PayloadType]
+	if !ok {
+		s.logf("Ignoring RTP packet with unknown payload type %d", pkt.PayloadType)
+		return nil
+	}
+
+	duration, err := s.packetDuration(pkt, payload)
+	if err != nil {
+		s.logf("Ignoring RTP packet: %s", err.Error())
+		return nil
+	}
+
+	gap := 0
+
+	if !s.started || pkt.SSRC != s.ssrc || pkt.PayloadType != s.payloadType {
+		s.startStream(pkt, payload)
+	} else {
+		gap = int(int32(pkt.Timestamp - s.nextTimestamp))
+		maxGap := payload.clockRate * maxRTPGapMs / 1000
+
+		if gap > maxGap || gap < -maxGap {
+			s.startStream(pkt, payload)
+			gap = 0
+		} else if gap < 0 {
+			// late or duplicate packet
+			s.nLate++
+
+			// receiver can still put it in order, but in transcoding mode
+			// its period is already concealed
+			if s.isPassthrough() {
+				return s.forwardLatePacket(pkt)
+			}
+			return nil
+		}
+	}
+
+	s.nextTimestamp = pkt.Timestamp + uint32(duration)
+	s.nGapSamples += gap
+	s.nPackets++
+
+	if s.logStats.Allow() {
+		rtpLog.Debug("rtp_stats", log.Fields{
+			"packets":         s.nPackets,
+			"late_packets":    s.nLate,
+			"missing_samples": s.nGapSamples,
+		}, "Received %d RTP packets, %d late packets, missing %d samples",
+			s.nPackets, s.nLate, s.nGapSamples)
+		s.nPackets, s.nLate, s.nGapSamples = 0, 0, 0
+	}
+
+	if s.isPassthrough() {
+		return s.forwardPacket(pkt, duration)
+	}
+
+	return s.decodePacket(pkt, gap)
+}
+
+func (s *RTPSource) startStream(pkt *rtp.Packet, payload rtpPayload) {
+	mode := "transcoding"
+	if payload.name == "opus" && !s.params.Transcode {
+		mode = "passthrough"
+	}
+
+	rtpLog.Info("rtp_stream_started", log.Fields{
+		"ssrc":         pkt.SSRC,
+		"payload_type": pkt.PayloadType,
+		"encoding":     payload.String(),
+		"mode":         mode,
+	}, "Starting RTP stream: ssrc=%d payload=%d(%s) mode=%s",
+		pkt.SSRC, pkt.PayloadType, payload, mode)
+
+	s.started = true
+	s.ssrc = pkt.SSRC
+	s.payloadType = pkt.PayloadType
+	s.payload = payload
+
+	// continue outgoing stream from where previous one ended
+	s.seqOffset = s.nextOutSeq - pkt.SequenceNumber
+	s.tsOffset = s.nextOutTstamp - pkt.Timestamp
+	s.firstOutSeq = s.nextOutSeq
+
+	if payload.name != "opus" {
+		s.resampler = dsp.NewResampler(payload.clockRate, s.params.Rate, s.params.Channels)
+	}
+}
+
+func (s *RTPSource) isPassthrough() bool {
+	return s.payload.name == "opus" && !s.params.Transcode
+}
+
+// returns packet duration in RTP clock units
+func (s *RTPSource) packetDuration(pkt *rtp.Packet, payload rtpPayload) (int, error) {
+	switch payload.name {
+	case "opus":
+		return opusPacketSamples(pkt.Payload)
+	case "PCMU", "PCMA":
+		return len(pkt.Payload) / payload.channels, nil
+	default:
+		return len(pkt.Payload) / 2 / payload.channels, nil
+	}
+}
+
+func (s *RTPSource) forwardPacket(pkt *rtp.Packet, duration int) error {
+	pkt.SequenceNumber += s.seqOffset
+	pkt.Timestamp += s.tsOffset
+
+	s.nextOutSeq = pkt.SequenceNumber + 1
+	s.nextOutTstamp = pkt.Timestamp + uint32(duration)
+
+	return s.peer.WriteRTP(pkt)
+}
+
+// forwards packet with the place in sequence it had in incoming stream,
+// without advancing outgoing stream
+func (s *RTPSource) forwardLatePacket(pkt *rtp.Packet) error {
+	pkt.SequenceNumber += s.seqOffset
+	pkt.Timestamp += s.tsOffset
+
+	// packet precedes current stream, its sequence number would
+	// clash with the previous one
+	if int16(pkt.SequenceNumber-s.firstOutSeq) < 0 {
+		return nil
+	}
+
+	return s.peer.WriteRTP(pkt)
+}
+
+func (s *RTPSource) decodePacket(pkt *rtp.Packet, gap int) error {
+	var pcm []int16
+
+	if s.payload.name == "opus" {
+		if s.decoder == nil {
+			var err error
+			s.decoder, err = opus.NewDecoder(s.params.Rate, s.params.Channels)
+			if err != nil {
+				return fmt.Errorf("can't create opus decoder: %s", err.Error())
+			}
+		}
+
+		// conceal missing samples using PLC, which works with fixed granularity
+		gapFrames := gap * s.params.Rate / s.payload.clockRate
+		if granularity := s.params.Rate / plcGranularityPerSec; gapFrames%granularity != 0 {
+			gapFrames += granularity - gapFrames%granularity
+		}
+
+		pcm = make([]int16, gapFrames*s.params.Channels,
+			(gapFrames+s.params.Rate*maxFrameMs/1000)*s.params.Channels)
+
+		if gapFrames > 0 {
+			// on failure, missing samples remain silent
+			_ = s.decoder.DecodePLC(pcm[:len(pcm):len(pcm)])
+		}
+
+		buf := pcm[len(pcm):cap(pcm)]
+
+		n, err := s.decoder.Decode(pkt.Payload, buf)
+		if err != nil {
+			return fmt.Errorf("can't decode opus frame: %s", err.Error())
+		}
+
+		pcm = pcm[:len(pcm)+n*s.params.Channels]
+	} else {
+		pcm = make([]int16, gap*s.payload.channels, len(pkt.Payload)+gap*s.payload.channels)
+
+		switch s.payload.name {
+		case "PCMU":
+			for _, b := range pkt.Payload {
+				pcm = append(pcm, ulawToLinear(b))
+			}
+		case "PCMA":
+			for _, b := range pkt.Payload {
+				pcm = append(pcm, alawToLinear(b))
+			}
+		case "L16":
+			for i := 0; i+1 < len(pkt.Payload); i += 2 {
+				pcm = append(pcm, int16(binary.BigEndian.Uint16(pkt.Payload[i:])))
+			}
+		}
+
+		pcm = dsp.ConvertChannels(pcm, s.payload.channels, s.params.Channels)
+		pcm = s.resampler.Process(pcm)
+	}
+
+	s.pending = append(s.pending, pcm...)
+
+	// peer encodes every write into a single opus frame
+	for len(s.pending) >= s.frameSize {
+		if err := s.peer.Write(s.pending[:s.frameSize]); err != nil {
+			return err
+		}
+		s.pending = s.pending[s.frameSize:]
+	}
+
+	return nil
+}
+
+// fills payload types from SDP file and returns address from it, if any
+func (s *RTPSource) readSDP(path string) (string, string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("can't read sdp file: %s", err.Error())
+	}
+
+	desc := sdp.SessionDescription{}
+	if err := desc.Unmarshal(b); err != nil {
+		return "", "", fmt.Errorf("can't parse sdp file: %s", err.Error())
+	}
+
+	for _, md := range desc.MediaDescriptions {
+		if md.MediaName.Media != "audio" {
+			continue
+		}
+
+		for _, format := range md.MediaName.Formats {
+			pt, err := strconv.Atoi(format)
+			if err != nil || pt < 0 || pt > 127 {
+				return "", "", fmt.Errorf("invalid payload type %q in sdp file", format)
+			}
+
+			codec, err := desc.GetCodecForPayloadType(uint8(pt))
+			if err != nil {
+				// static payload types may be used without rtpmap
+				if _, ok := staticRTPPayloads[uint8(pt)]; ok {
+					continue
+				}
+				return "", "", fmt.Errorf("no rtpmap for payload type %d in sdp file", pt)
+			}
+
+			payload, err := parseSDPCodec(codec)
+			if err != nil {
+				return "", "", err
+			}
+
+			s.payloads[uint8(pt)] = payload
+		}
+
+		var host string
+		if md.ConnectionInformation != nil && md.ConnectionInformation.Address != nil {
+			host = md.ConnectionInformation.Address.Address
+		} else if desc.ConnectionInformation != nil && desc.ConnectionInformation.Address != nil {
+			host = desc.ConnectionInformation.Address.Address
+		}
+
+		// strip TTL and number of addresses
+		host = strings.Split(host, "/")[0]
+
+		// only multicast address is useful for listening
+		if ip := net.ParseIP(host); ip == nil || !ip.IsMulticast() {
+			host = ""
+		}
+
+		return host, strconv.Itoa(md.MediaName.Port.Value), nil
+	}
+
+	return "", "", errors.New("no audio media in sdp file")
+}
+
+func parseSDPCodec(codec sdp.Codec) (rtpPayload, error) {
+	payload := rtpPayload{
+		clockRate: int(codec.ClockRate),
+		channels:  1,
+	}
+
+	switch strings.ToUpper(codec.Name) {
+	case "OPUS":
+		payload.name = "opus"
+		payload.clockRate = opusGranuleRate
+		payload.channels = 2
+		return payload, nil
+	case "PCMU", "PCMA", "L16":
+		payload.name = strings.ToUpper(codec.Name)
+	default:
+		return payload, fmt.Errorf("unsupported codec %q in sdp file", codec.Name)
+	}
+
+	if codec.EncodingParameters != "" {
+		ch, err := strconv.Atoi(codec.EncodingParameters)
+		if err != nil || (ch != 1 && ch != 2) {
+			return payload, fmt.Errorf("unsupported channels %q in sdp file",
+				codec.EncodingParameters)
+		}
+		payload.channels = ch
+	}
+
+	if payload.clockRate <= 0 {
+		return payload, fmt.Errorf("invalid clock rate for %q in sdp file", codec.Name)
+	}
+
+	return payload, nil
+}
+
+func (s *RTPSource) logf(format string, args ...interface{}) {
+	if s.logDrop.Allow() {
+		rtpLog.Warn("rtp_packet_ignored", nil, format, args...)
+	}
+}
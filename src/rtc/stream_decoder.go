@@ -0,0 +1,117 @@
+package rtc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pion/rtp"
+	"gopkg.in/gavv/opus.v2"
+
+	"github.com/gavv/webrtc-cli/src/log"
+)
+
+// decodes opus stream without peer connection, e.g. from capture file,
+// using the same reordering, FEC, and PLC logic as peer; since packets
+// don't arrive in real time, arrival times are provided by caller
+type StreamDecoder struct {
+	depacketizer *depacketizer
+
+	// set if reordering is enabled
+	reorder *reorderBuffer
+
+	// pushed packets, if reordering is disabled
+	pending []*rtp.Packet
+
+	// only the first stream is decoded
+	ssrc    uint32
+	started bool
+}
+
+func NewStreamDecoder(
+	rate, channels int, reorderWindow time.Duration, debug bool,
+) (*StreamDecoder, error) {
+	decoder, err := opus.NewDecoder(rate, channels)
+	if err != nil {
+		return nil, fmt.Errorf("can't create opus decoder: %s", err.Error())
+	}
+
+	// there is no SDP, so we assume that FEC may be present
+	d := &StreamDecoder{
+		depacketizer: newDepacketizer(decoder, true, rate, channels, debug),
+	}
+
+	if reorderWindow > 0 {
+		d.reorder = newReorderBuffer(reorderWindow, debug)
+	}
+
+	return d, nil
+}
+
+// adds RTP packet arrived at given time, packets of other streams are ignored;
+// packets are decoded by Next
+func (d *StreamDecoder) Push(b []byte, now time.Time) error {
+	pkt := &rtp.Packet{}
+	if err := pkt.Unmarshal(b); err != nil {
+		return fmt.Errorf("can't parse RTP packet: %s", err.Error())
+	}
+
+	if !d.started {
+		decoderLog.Info("stream_selected", log.Fields{"ssrc": pkt.SSRC},
+			"Decoding stream with SSRC %d", pkt.SSRC)
+		d.ssrc = pkt.SSRC
+		d.started = true
+	}
+
+	if pkt.SSRC != d.ssrc {
+		return nil
+	}
+
+	if d.reorder != nil {
+		d.reorder.push(pkt, now)
+	} else {
+		d.pending = append(d.pending, pkt)
+	}
+
+	return nil
+}
+
+// decodes next packet that can be released at given time, and returns its
+// samples and RTP timestamp; returns false if there are no such packets
+func (d *StreamDecoder) Next(now time.Time) ([]int16, uint32, bool, error) {
+	var pkt *rtp.Packet
+	if d.reorder != nil {
+		pkt = d.reorder.pop(now)
+	} else if len(d.pending) != 0 {
+		pkt, d.pending = d.pending[0], d.pending[1:]
+	}
+
+	if pkt == nil {
+		return nil, 0, false, nil
+	}
+
+	buf, err := d.depacketizer.getSamples(pkt)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	return buf, pkt.Timestamp, true, nil
+}
+
+// returns time when packet held by reorder buffer should be released
+// even if there is a gap before it
+func (d *StreamDecoder) Deadline() (time.Time, bool) {
+	if d.reorder == nil {
+		return time.Time{}, false
+	}
+	return d.reorder.deadline()
+}
+
+// same as Peer.Conceal
+func (d *StreamDecoder) Conceal(numSamples int) []int16 {
+	return d.depacketizer.conceal(numSamples)
+}
+
+// same as Peer.Extrapolate
+func (d *StreamDecoder) Extrapolate(numSamples int) []int16 {
+	return d.depacketizer.extrapolate(numSamples)
+}
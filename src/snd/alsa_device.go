@@ -0,0 +1,163 @@
+package snd
+
+/*
+#cgo pkg-config: alsa
+
+#include <stdlib.h>
+#include <alsa/asoundlib.h>
+
+static int alsa_configure(snd_pcm_t* pcm, unsigned int rate, unsigned int channels,
+                          unsigned int* buffer_us, unsigned int* period_us) {
+    snd_pcm_hw_params_t* hw = NULL;
+    int err;
+
+    if ((err = snd_pcm_hw_params_malloc(&hw)) < 0) {
+        return err;
+    }
+
+    if ((err = snd_pcm_hw_params_any(pcm, hw)) < 0) {
+        goto out;
+    }
+    if ((err = snd_pcm_hw_params_set_access(pcm, hw, SND_PCM_ACCESS_RW_INTERLEAVED)) < 0) {
+        goto out;
+    }
+    if ((err = snd_pcm_hw_params_set_format(pcm, hw, SND_PCM_FORMAT_S16_LE)) < 0) {
+        goto out;
+    }
+    if ((err = snd_pcm_hw_params_set_channels(pcm, hw, channels)) < 0) {
+        goto out;
+    }
+    if ((err = snd_pcm_hw_params_set_rate_resample(pcm, hw, 1)) < 0) {
+        goto out;
+    }
+    if ((err = snd_pcm_hw_params_set_rate(pcm, hw, rate, 0)) < 0) {
+        goto out;
+    }
+    if (*period_us != 0) {
+        if ((err = snd_pcm_hw_params_set_period_time_near(pcm, hw, period_us, NULL)) < 0) {
+            goto out;
+        }
+    }
+    if (*buffer_us != 0) {
+        if ((err = snd_pcm_hw_params_set_buffer_time_near(pcm, hw, buffer_us, NULL)) < 0) {
+            goto out;
+        }
+    }
+    if ((err = snd_pcm_hw_params(pcm, hw)) < 0) {
+        goto out;
+    }
+
+    err = snd_pcm_prepare(pcm);
+
+out:
+    snd_pcm_hw_params_free(hw);
+    return err;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+const alsaPrefix = "alsa:"
+
+func isAlsaDevice(device string) bool {
+	return strings.HasPrefix(device, alsaPrefix)
+}
+
+func alsaDeviceName(device string) string {
+	name := strings.TrimPrefix(device, alsaPrefix)
+	if name == "" {
+		return "default"
+	}
+	return name
+}
+
+type alsaDevice struct {
+	pcm      *C.snd_pcm_t
+	channels int
+}
+
+func openAlsaDevice(params Params, capture bool) (*alsaDevice, error) {
+	name := C.CString(alsaDeviceName(params.DeviceOrFile))
+	defer C.free(unsafe.Pointer(name))
+
+	stream := C.snd_pcm_stream_t(C.SND_PCM_STREAM_PLAYBACK)
+	if capture {
+		stream = C.SND_PCM_STREAM_CAPTURE
+	}
+
+	d := &alsaDevice{
+		channels: params.Channels,
+	}
+
+	if err := C.snd_pcm_open(&d.pcm, name, stream, 0); err < 0 {
+		return nil, alsaError(err)
+	}
+
+	bufferUs := C.uint(params.BufferLength / time.Microsecond)
+	periodUs := C.uint(params.PeriodLength / time.Microsecond)
+
+	err := C.alsa_configure(d.pcm,
+		C.uint(params.Rate), C.uint(params.Channels), &bufferUs, &periodUs)
+	if err < 0 {
+		C.snd_pcm_close(d.pcm)
+		return nil, alsaError(err)
+	}
+
+	return d, nil
+}
+
+func (d *alsaDevice) close() {
+	C.snd_pcm_close(d.pcm)
+}
+
+func (d *alsaDevice) read(data []int16) error {
+	for len(data) != 0 {
+		frames := C.snd_pcm_uframes_t(len(data) / d.channels)
+
+		n := C.snd_pcm_readi(d.pcm, unsafe.Pointer(&data[0]), frames)
+		if n < 0 {
+			// recover from overrun or suspend and try again
+			if err := C.snd_pcm_recover(d.pcm, C.int(n), 1); err < 0 {
+				return alsaError(err)
+			}
+			continue
+		}
+
+		data = data[int(n)*d.channels:]
+	}
+
+	return nil
+}
+
+func (d *alsaDevice) write(data []int16) error {
+	for len(data) != 0 {
+		frames := C.snd_pcm_uframes_t(len(data) / d.channels)
+
+		n := C.snd_pcm_writei(d.pcm, unsafe.Pointer(&data[0]), frames)
+		if n < 0 {
+			// recover from underrun or suspend and try again
+			if err := C.snd_pcm_recover(d.pcm, C.int(n), 1); err < 0 {
+				return alsaError(err)
+			}
+			continue
+		}
+
+		data = data[int(n)*d.channels:]
+	}
+
+	return nil
+}
+
+func (d *alsaDevice) drop() {
+	C.snd_pcm_drop(d.pcm)
+}
+
+func alsaError(code C.int) error {
+	return fmt.Errorf("%s", C.GoString(C.snd_strerror(code)))
+}
@@ -0,0 +1,176 @@
+package snd
+
+/*
+#cgo pkg-config: alsa
+
+#include <stdlib.h>
+#include <alsa/asoundlib.h>
+
+static const snd_pcm_format_t alsa_formats[] = {
+    SND_PCM_FORMAT_S16_LE,
+    SND_PCM_FORMAT_S24_LE,
+    SND_PCM_FORMAT_S24_3LE,
+    SND_PCM_FORMAT_S32_LE,
+    SND_PCM_FORMAT_FLOAT_LE,
+    SND_PCM_FORMAT_U8,
+};
+
+#define ALSA_NUM_FORMATS (sizeof(alsa_formats) / sizeof(alsa_formats[0]))
+
+static void* alsa_hint_at(void** hints, int n) {
+    return hints[n];
+}
+
+static int alsa_query(const char* name, int capture,
+                      unsigned int* min_rate, unsigned int* max_rate,
+                      unsigned int* min_channels, unsigned int* max_channels,
+                      unsigned int* formats) {
+    snd_pcm_t* pcm = NULL;
+    snd_pcm_hw_params_t* hw = NULL;
+    unsigned int n;
+    int err;
+
+    snd_pcm_stream_t stream = capture ? SND_PCM_STREAM_CAPTURE : SND_PCM_STREAM_PLAYBACK;
+
+    if ((err = snd_pcm_open(&pcm, name, stream, SND_PCM_NONBLOCK)) < 0) {
+        return err;
+    }
+
+    if ((err = snd_pcm_hw_params_malloc(&hw)) < 0) {
+        snd_pcm_close(pcm);
+        return err;
+    }
+
+    if ((err = snd_pcm_hw_params_any(pcm, hw)) < 0) {
+        goto out;
+    }
+
+    snd_pcm_hw_params_get_rate_min(hw, min_rate, NULL);
+    snd_pcm_hw_params_get_rate_max(hw, max_rate, NULL);
+    snd_pcm_hw_params_get_channels_min(hw, min_channels);
+    snd_pcm_hw_params_get_channels_max(hw, max_channels);
+
+    *formats = 0;
+    for (n = 0; n < ALSA_NUM_FORMATS; n++) {
+        if (snd_pcm_hw_params_test_format(pcm, hw, alsa_formats[n]) == 0) {
+            *formats |= 1u << n;
+        }
+    }
+
+out:
+    snd_pcm_hw_params_free(hw);
+    snd_pcm_close(pcm);
+    return err;
+}
+*/
+import "C"
+
+import (
+	"strings"
+	"unsafe"
+)
+
+// same order as alsa_formats
+var alsaFormatNames = []string{
+	"S16_LE",
+	"S24_LE",
+	"S24_3LE",
+	"S32_LE",
+	"FLOAT_LE",
+	"U8",
+}
+
+func listAlsaDevices(capture bool) ([]Device, error) {
+	iface := C.CString("pcm")
+	defer C.free(unsafe.Pointer(iface))
+
+	var hints *unsafe.Pointer
+	if err := C.snd_device_name_hint(-1, iface, &hints); err < 0 {
+		return nil, alsaError(err)
+	}
+	defer C.snd_device_name_free_hint(hints)
+
+	var devices []Device
+
+	for n := 0; ; n++ {
+		hint := C.alsa_hint_at(hints, C.int(n))
+		if hint == nil {
+			break
+		}
+
+		name := alsaHintString(hint, "NAME")
+		if name == "" {
+			continue
+		}
+
+		// missing IOID means that device supports both directions
+		switch alsaHintString(hint, "IOID") {
+		case "Input":
+			if !capture {
+				continue
+			}
+		case "Output":
+			if capture {
+				continue
+			}
+		}
+
+		dev := Device{
+			Name:        alsaPrefix + name,
+			Backend:     "alsa",
+			Description: strings.Replace(alsaHintString(hint, "DESC"), "\n", ", ", -1),
+			Default:     name == "default",
+		}
+
+		queryAlsaDevice(&dev, name, capture)
+
+		devices = append(devices, dev)
+	}
+
+	return devices, nil
+}
+
+// fills supported formats, leaves them empty if device can't be opened
+func queryAlsaDevice(dev *Device, name string, capture bool) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	var (
+		minRate, maxRate         C.uint
+		minChannels, maxChannels C.uint
+		formats                  C.uint
+	)
+
+	isCapture := C.int(0)
+	if capture {
+		isCapture = 1
+	}
+
+	err := C.alsa_query(cname, isCapture,
+		&minRate, &maxRate, &minChannels, &maxChannels, &formats)
+	if err < 0 {
+		return
+	}
+
+	for n, format := range alsaFormatNames {
+		if formats&(1<<uint(n)) != 0 {
+			dev.Formats = append(dev.Formats, format)
+		}
+	}
+
+	dev.MinRate, dev.MaxRate = int(minRate), int(maxRate)
+	dev.MinChannels, dev.MaxChannels = int(minChannels), int(maxChannels)
+}
+
+func alsaHintString(hint unsafe.Pointer, id string) string {
+	cid := C.CString(id)
+	defer C.free(unsafe.Pointer(cid))
+
+	s := C.snd_device_name_get_hint(hint, cid)
+	if s == nil {
+		return ""
+	}
+	defer C.free(unsafe.Pointer(s))
+
+	return C.GoString(s)
+}
@@ -0,0 +1,90 @@
+package snd
+
+import (
+	"fmt"
+	"runtime"
+)
+
+type AlsaPlayer struct {
+	initCh   chan error
+	dataCh   chan []int16
+	errCh    chan error
+	cancelCh chan struct{}
+	doneCh   chan struct{}
+}
+
+func NewAlsaPlayer(params Params) (*AlsaPlayer, error) {
+	a := &AlsaPlayer{
+		initCh:   make(chan error, 1),
+		dataCh:   make(chan []int16, 0),
+		errCh:    make(chan error, 1),
+		cancelCh: make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+
+	go func() {
+		runtime.LockOSThread()
+		a.runPlayback(params)
+	}()
+
+	if err := <-a.initCh; err != nil {
+		<-a.doneCh
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *AlsaPlayer) Batches() chan<- []int16 {
+	return a.dataCh
+}
+
+func (a *AlsaPlayer) Errors() <-chan error {
+	return a.errCh
+}
+
+func (a *AlsaPlayer) Stopped() <-chan struct{} {
+	return a.cancelCh
+}
+
+func (a *AlsaPlayer) Stop() {
+	close(a.cancelCh)
+	<-a.doneCh
+}
+
+func (a *AlsaPlayer) runPlayback(params Params) {
+	defer func() {
+		close(a.doneCh)
+		close(a.errCh)
+	}()
+
+	dev, err := openAlsaDevice(params, false)
+	if err != nil {
+		a.initCh <- fmt.Errorf("can't open alsa playback device: %s", err.Error())
+		return
+	}
+
+	close(a.initCh)
+
+	defer dev.close()
+
+	for {
+		var data []int16
+
+		select {
+		case data = <-a.dataCh:
+		case <-a.cancelCh:
+			dev.drop()
+			return
+		}
+
+		if len(data) == 0 {
+			continue
+		}
+
+		if err := dev.write(data); err != nil {
+			a.errCh <- fmt.Errorf("can't write to alsa playback device: %s", err.Error())
+			return
+		}
+	}
+}
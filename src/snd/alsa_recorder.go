@@ -0,0 +1,89 @@
+package snd
+
+import (
+	"fmt"
+	"runtime"
+)
+
+type AlsaRecorder struct {
+	initCh   chan error
+	batchCh  chan Batch
+	cancelCh chan struct{}
+	doneCh   chan struct{}
+}
+
+func NewAlsaRecorder(params Params) (*AlsaRecorder, error) {
+	a := &AlsaRecorder{
+		initCh:   make(chan error, 1),
+		batchCh:  make(chan Batch, 1),
+		cancelCh: make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+
+	go func() {
+		runtime.LockOSThread()
+		a.runRecording(params)
+	}()
+
+	if err := <-a.initCh; err != nil {
+		<-a.doneCh
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *AlsaRecorder) Batches() <-chan Batch {
+	return a.batchCh
+}
+
+func (a *AlsaRecorder) Stop() {
+	close(a.cancelCh)
+	<-a.doneCh
+}
+
+func (a *AlsaRecorder) runRecording(params Params) {
+	defer func() {
+		close(a.doneCh)
+		close(a.batchCh)
+	}()
+
+	dev, err := openAlsaDevice(params, true)
+	if err != nil {
+		a.initCh <- fmt.Errorf("can't open alsa capture device: %s", err.Error())
+		return
+	}
+
+	close(a.initCh)
+
+	defer dev.close()
+
+	frameSamples := durationToSamples(params.FrameLength, params.Rate) * params.Channels
+
+	for {
+		select {
+		case <-a.cancelCh:
+			return
+		default:
+		}
+
+		data := make([]int16, frameSamples)
+
+		if err := dev.read(data); err != nil {
+			a.batchCh <- Batch{
+				Err: fmt.Errorf("can't read from alsa capture device: %s", err.Error()),
+			}
+			return
+		}
+
+		batch := Batch{
+			Data: data,
+		}
+
+		select {
+		case a.batchCh <- batch:
+		case <-a.cancelCh:
+			return
+		}
+	}
+}
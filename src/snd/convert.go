@@ -3,6 +3,7 @@ package snd
 import (
 	"bytes"
 	"encoding/binary"
+	"math"
 	"time"
 )
 
@@ -10,6 +11,17 @@ func durationToSamples(d time.Duration, rate int) int {
 	return rate * int(d/time.Millisecond) / 1000
 }
 
+func floatToInt16(v float64) int16 {
+	v = math.Round(v * math.MaxInt16)
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
+
 func bytesToInt16(b []byte) []int16 {
 	if len(b)%2 != 0 {
 		panic("bad byte slice size")
@@ -0,0 +1,90 @@
+package snd
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type Device struct {
+	// value for --source or --sink
+	Name string
+
+	Backend     string
+	Description string
+	Default     bool
+
+	// supported sample formats, the first one is the default
+	Formats []string
+
+	MinRate int
+	MaxRate int
+
+	MinChannels int
+	MaxChannels int
+}
+
+// lists capture or playback devices of all backends,
+// and errors from backends that are not available
+func ListDevices(capture bool) ([]Device, []error) {
+	var (
+		devices []Device
+		errs    []error
+	)
+
+	if list, err := listPulseDevices(capture); err == nil {
+		devices = append(devices, list...)
+	} else {
+		errs = append(errs, err)
+	}
+
+	if list, err := listAlsaDevices(capture); err == nil {
+		devices = append(devices, list...)
+	} else {
+		errs = append(errs, err)
+	}
+
+	// backends have own default devices, but "default" alias selects
+	// the first one, so only it is reported as default
+	hasDefault := false
+	for n := range devices {
+		if devices[n].Default {
+			devices[n].Default = !hasDefault
+			hasDefault = true
+		}
+	}
+
+	return devices, errs
+}
+
+func isDeviceAlias(device string) bool {
+	if device == "default" {
+		return true
+	}
+	_, err := strconv.ParseUint(device, 10, 32)
+	return err == nil
+}
+
+// replaces "default" and device index from ListDevices with device name
+func resolveDeviceAlias(device string, capture bool) (string, error) {
+	devices, errs := ListDevices(capture)
+
+	if device == "default" {
+		for _, dev := range devices {
+			if dev.Default {
+				return dev.Name, nil
+			}
+		}
+		if len(errs) != 0 {
+			return "", fmt.Errorf("can't find default device: %s", errs[0].Error())
+		}
+		return "", fmt.Errorf("can't find default device")
+	}
+
+	index, _ := strconv.Atoi(device)
+	if index >= len(devices) {
+		return "", fmt.Errorf("invalid device index %d: found only %d devices",
+			index, len(devices))
+	}
+
+	return devices[index].Name, nil
+}
@@ -1,12 +1,27 @@
 package snd
 
 import (
+	"bufio"
+	"bytes"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
-func isWavFile(deviceOrFile string) bool {
-	if strings.HasSuffix(strings.ToLower(deviceOrFile), ".wav") {
+// output files are detected only by extension or explicit path,
+// so that existing file is not overwritten when it has the same name
+// as a device
+func isOutputFile(deviceOrFile string) bool {
+	if strings.ToLower(filepath.Ext(deviceOrFile)) == ".wav" {
+		return true
+	}
+	return strings.Contains(deviceOrFile, "/")
+}
+
+func isFile(deviceOrFile string) bool {
+	switch strings.ToLower(filepath.Ext(deviceOrFile)) {
+	case ".wav", ".flac", ".mp3", ".m3u", ".m3u8":
 		return true
 	}
 	if strings.Contains(deviceOrFile, "/") {
@@ -17,3 +32,88 @@ func isWavFile(deviceOrFile string) bool {
 	}
 	return false
 }
+
+// channels is used by decoders that can convert channel layout
+func openFileDecoder(path string, channels int) (fileDecoder, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".wav":
+		return newWavDecoder(path)
+	case ".flac":
+		return newFlacDecoder(path)
+	case ".mp3":
+		return newMp3Decoder(path, channels)
+	}
+
+	switch detectFileFormat(path) {
+	case "flac":
+		return newFlacDecoder(path)
+	case "mp3":
+		return newMp3Decoder(path, channels)
+	default:
+		return newWavDecoder(path)
+	}
+}
+
+func detectFileFormat(path string) string {
+	fp, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer fp.Close()
+
+	var magic [12]byte
+	n, _ := io.ReadFull(fp, magic[:])
+	b := magic[:n]
+
+	switch {
+	case bytes.HasPrefix(b, []byte("RIFF")) && len(b) >= 12 && string(b[8:12]) == "WAVE":
+		return "wav"
+	case bytes.HasPrefix(b, []byte("fLaC")):
+		return "flac"
+	case bytes.HasPrefix(b, []byte("ID3")) && len(b) >= 10:
+		// both flac and mp3 files may start with ID3v2 tag, look after it
+		size := int64(b[6])<<21 | int64(b[7])<<14 | int64(b[8])<<7 | int64(b[9])
+		var next [4]byte
+		if _, err := fp.ReadAt(next[:], 10+size); err == nil && string(next[:]) == "fLaC" {
+			return "flac"
+		}
+		return "mp3"
+	case len(b) >= 2 && b[0] == 0xff && b[1]&0xe0 == 0xe0:
+		// mpeg audio frame sync
+		return "mp3"
+	}
+
+	return ""
+}
+
+// buffered reader preserving seek semantics of underlying reader
+type bufReadSeeker struct {
+	rs io.ReadSeeker
+	rd *bufio.Reader
+}
+
+func newBufReadSeeker(rs io.ReadSeeker) *bufReadSeeker {
+	return &bufReadSeeker{
+		rs: rs,
+		rd: bufio.NewReader(rs),
+	}
+}
+
+func (b *bufReadSeeker) Read(p []byte) (int, error) {
+	return b.rd.Read(p)
+}
+
+func (b *bufReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekCurrent {
+		offset -= int64(b.rd.Buffered())
+	}
+
+	pos, err := b.rs.Seek(offset, whence)
+	if err != nil {
+		return 0, err
+	}
+
+	b.rd.Reset(b.rs)
+
+	return pos, nil
+}
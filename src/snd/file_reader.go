@@ -0,0 +1,330 @@
+package snd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type fileDecoder interface {
+	// sample rate and number of channels
+	format() (int, int)
+
+	// read interleaved samples, returns io.EOF at the end of file
+	read(buf []int16) (int, error)
+
+	// seek to given per-channel sample
+	seek(frame int) error
+
+	close() error
+}
+
+type queueEntry struct {
+	item    int
+	newPass bool
+}
+
+type openResult struct {
+	decoder fileDecoder
+	err     error
+}
+
+// reads a single file or a playlist of files as one continuous stream
+type FileReader struct {
+	params Params
+
+	items []string
+	queue []queueEntry
+
+	rng *rand.Rand
+
+	decoder fileDecoder
+	nextCh  chan openResult
+
+	startFrame  int
+	segmentSize int
+	segmentLeft int
+	gapSize     int
+	gapLeft     int
+
+	// nothing was read since the beginning of the playlist
+	passEmpty bool
+
+	batchCh  chan Batch
+	cancelCh chan struct{}
+	doneCh   chan struct{}
+}
+
+func NewFileReader(params Params) (*FileReader, error) {
+	items, err := readPlaylist(params.DeviceOrFile)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FileReader{
+		params:     params,
+		items:      items,
+		rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		startFrame: durationToSamples(params.Start, params.Rate),
+		// negative if unlimited
+		segmentSize: -1,
+		gapSize:     durationToSamples(params.Gap, params.Rate) * params.Channels,
+		passEmpty:   true,
+		batchCh:     make(chan Batch, 64),
+		cancelCh:    make(chan struct{}),
+		doneCh:      make(chan struct{}),
+	}
+
+	if params.Duration > 0 {
+		f.segmentSize = durationToSamples(params.Duration, params.Rate) * params.Channels
+	}
+
+	f.enqueuePass(false)
+
+	// open first file synchronously to report errors early
+	decoder, err := f.openItem(f.items[f.queue[0].item])
+	if err != nil {
+		return nil, err
+	}
+
+	f.queue = f.queue[1:]
+	f.startItem(decoder)
+
+	go f.runReading()
+
+	return f, nil
+}
+
+func (f *FileReader) Batches() <-chan Batch {
+	return f.batchCh
+}
+
+func (f *FileReader) Stop() {
+	close(f.cancelCh)
+	<-f.doneCh
+}
+
+func (f *FileReader) runReading() {
+	defer close(f.doneCh)
+	defer close(f.batchCh)
+
+	defer f.closeDecoders()
+
+	samplesPerFramePerChan := durationToSamples(f.params.FrameLength, f.params.Rate)
+
+	limiter := rate.NewLimiter(rate.Limit(f.params.Rate), samplesPerFramePerChan)
+
+	for {
+		select {
+		case <-f.cancelCh:
+			return
+		default:
+		}
+
+		data := make([]int16, samplesPerFramePerChan*f.params.Channels)
+
+		n, eof, err := f.fillBatch(data)
+		if err != nil {
+			f.batchCh <- Batch{
+				Err: err,
+			}
+			return
+		}
+
+		if n == 0 {
+			return
+		}
+
+		limiter.WaitN(context.TODO(), samplesPerFramePerChan)
+
+		// last batch is padded with zeros
+		select {
+		case f.batchCh <- Batch{Data: data}:
+		case <-f.cancelCh:
+			return
+		}
+
+		if eof {
+			return
+		}
+	}
+}
+
+func (f *FileReader) fillBatch(data []int16) (int, bool, error) {
+	n := 0
+
+	for n < len(data) {
+		// silence between playlist items, already zeroed
+		if f.gapLeft > 0 {
+			count := len(data) - n
+			if count > f.gapLeft {
+				count = f.gapLeft
+			}
+			n += count
+			f.gapLeft -= count
+			continue
+		}
+
+		if f.decoder == nil {
+			ok, err := f.nextItem()
+			if err != nil {
+				return n, false, err
+			}
+			if !ok {
+				return n, true, nil
+			}
+			continue
+		}
+
+		buf := data[n:]
+		if f.segmentLeft >= 0 && f.segmentLeft < len(buf) {
+			buf = buf[:f.segmentLeft]
+		}
+
+		var (
+			count int
+			err   error
+		)
+		if len(buf) != 0 {
+			count, err = f.decoder.read(buf)
+		} else {
+			err = io.EOF
+		}
+
+		if err != nil && err != io.EOF {
+			return n, false, fmt.Errorf("can't read from input file: %s", err.Error())
+		}
+
+		n += count
+		if f.segmentLeft >= 0 {
+			f.segmentLeft -= count
+		}
+		if count != 0 {
+			f.passEmpty = false
+		}
+
+		if err == io.EOF {
+			f.decoder.close()
+			f.decoder = nil
+		}
+	}
+
+	return n, false, nil
+}
+
+// switches to next playlist item, returns false at the end of playlist
+func (f *FileReader) nextItem() (bool, error) {
+	if len(f.queue) == 0 {
+		return false, nil
+	}
+
+	entry := f.queue[0]
+	f.queue = f.queue[1:]
+
+	if entry.newPass {
+		// don't loop forever if there is nothing to play
+		if f.passEmpty {
+			return false, nil
+		}
+		f.passEmpty = true
+	}
+
+	res := <-f.nextCh
+	f.nextCh = nil
+
+	if res.err != nil {
+		return false, res.err
+	}
+
+	f.startItem(res.decoder)
+	f.gapLeft = f.gapSize
+
+	return true, nil
+}
+
+func (f *FileReader) startItem(decoder fileDecoder) {
+	f.decoder = decoder
+	f.segmentLeft = f.segmentSize
+
+	if len(f.queue) == 0 && f.params.Loop {
+		f.enqueuePass(true)
+	}
+
+	// prepare next item in background, opening may take a while,
+	// e.g. when it requires to scan the whole file
+	if len(f.queue) != 0 {
+		path := f.items[f.queue[0].item]
+		ch := make(chan openResult, 1)
+
+		go func() {
+			decoder, err := f.openItem(path)
+			ch <- openResult{decoder, err}
+		}()
+
+		f.nextCh = ch
+	}
+}
+
+func (f *FileReader) openItem(path string) (fileDecoder, error) {
+	decoder, err := openFileDecoder(path, f.params.Channels)
+	if err != nil {
+		return nil, err
+	}
+
+	rate, channels := decoder.format()
+
+	if rate != f.params.Rate {
+		decoder.close()
+		return nil, fmt.Errorf("bad input file %s: need rate %d, got rate %d",
+			path, f.params.Rate, rate)
+	}
+
+	if channels != f.params.Channels {
+		decoder.close()
+		return nil, fmt.Errorf("bad input file %s: need %d channels, got %d channels",
+			path, f.params.Channels, channels)
+	}
+
+	if err := decoder.seek(f.startFrame); err != nil {
+		decoder.close()
+		return nil, err
+	}
+
+	return decoder, nil
+}
+
+func (f *FileReader) enqueuePass(newPass bool) {
+	order := make([]int, len(f.items))
+	for i := range order {
+		order[i] = i
+	}
+
+	if f.params.Shuffle {
+		f.rng.Shuffle(len(order), func(i, j int) {
+			order[i], order[j] = order[j], order[i]
+		})
+	}
+
+	for i, item := range order {
+		f.queue = append(f.queue, queueEntry{
+			item:    item,
+			newPass: newPass && i == 0,
+		})
+	}
+}
+
+func (f *FileReader) closeDecoders() {
+	if f.decoder != nil {
+		f.decoder.close()
+	}
+
+	if f.nextCh != nil {
+		if res := <-f.nextCh; res.err == nil {
+			res.decoder.close()
+		}
+	}
+}
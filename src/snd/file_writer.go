@@ -0,0 +1,126 @@
+package snd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// if writer falls behind real time by more than this, it doesn't try to catch up
+const maxWriteLag = time.Second
+
+// writes stream to wav file, blocking like a playback device would,
+// unless params.Unpaced is set
+type FileWriter struct {
+	encoder *wavEncoder
+	params  Params
+
+	dataCh   chan []int16
+	errCh    chan error
+	cancelCh chan struct{}
+	doneCh   chan struct{}
+}
+
+func NewFileWriter(params Params) (*FileWriter, error) {
+	switch strings.ToLower(filepath.Ext(params.DeviceOrFile)) {
+	case ".flac", ".mp3", ".m3u", ".m3u8":
+		return nil, fmt.Errorf("can't write %s: only wav files are supported for writing",
+			params.DeviceOrFile)
+	}
+
+	encoder, err := newWavEncoder(params.DeviceOrFile, params.Rate, params.Channels)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FileWriter{
+		encoder:  encoder,
+		params:   params,
+		dataCh:   make(chan []int16, 0),
+		errCh:    make(chan error, 1),
+		cancelCh: make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+
+	go f.runWriting()
+
+	return f, nil
+}
+
+func (f *FileWriter) Batches() chan<- []int16 {
+	return f.dataCh
+}
+
+func (f *FileWriter) Errors() <-chan error {
+	return f.errCh
+}
+
+func (f *FileWriter) Stopped() <-chan struct{} {
+	return f.cancelCh
+}
+
+// finalizes file, batches sent before Stop are written
+func (f *FileWriter) Stop() {
+	close(f.cancelCh)
+	<-f.doneCh
+}
+
+func (f *FileWriter) runWriting() {
+	defer func() {
+		close(f.doneCh)
+		close(f.errCh)
+	}()
+
+	defer func() {
+		if err := f.encoder.close(); err != nil {
+			select {
+			case f.errCh <- err:
+			default:
+			}
+		}
+	}()
+
+	var next time.Time
+
+	for {
+		var data []int16
+
+		select {
+		case data = <-f.dataCh:
+		case <-f.cancelCh:
+			return
+		}
+
+		if len(data) == 0 {
+			continue
+		}
+
+		if err := f.encoder.write(data); err != nil {
+			f.errCh <- err
+			return
+		}
+
+		if f.params.Unpaced {
+			continue
+		}
+
+		now := time.Now()
+		if next.IsZero() || now.Sub(next) > maxWriteLag {
+			next = now
+		}
+
+		next = next.Add(
+			time.Duration(len(data)/f.params.Channels) * time.Second /
+				time.Duration(f.params.Rate))
+
+		timer := time.NewTimer(next.Sub(now))
+
+		select {
+		case <-timer.C:
+		case <-f.cancelCh:
+			timer.Stop()
+			return
+		}
+	}
+}
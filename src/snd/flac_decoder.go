@@ -0,0 +1,165 @@
+package snd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mewkiz/flac"
+)
+
+type flacDecoder struct {
+	fp     *os.File
+	rs     *bufReadSeeker
+	stream *flac.Stream
+
+	dataStart int64
+
+	// decoded but not yet returned interleaved samples
+	pending []int16
+	eof     bool
+}
+
+func newFlacDecoder(path string) (*flacDecoder, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open flac file: %s", err.Error())
+	}
+
+	rs := newBufReadSeeker(fp)
+
+	stream, err := flac.NewSeek(rs)
+	if err != nil {
+		fp.Close()
+		return nil, fmt.Errorf("can't read flac file header: %s", err.Error())
+	}
+
+	dataStart, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		fp.Close()
+		return nil, fmt.Errorf("can't seek flac file: %s", err.Error())
+	}
+
+	d := &flacDecoder{
+		fp:        fp,
+		rs:        rs,
+		stream:    stream,
+		dataStart: dataStart,
+	}
+
+	return d, nil
+}
+
+func (d *flacDecoder) format() (int, int) {
+	return int(d.stream.Info.SampleRate), int(d.stream.Info.NChannels)
+}
+
+func (d *flacDecoder) close() error {
+	return d.fp.Close()
+}
+
+func (d *flacDecoder) seek(frame int) error {
+	d.pending = nil
+	d.eof = false
+
+	// fast path, doesn't require building seek table
+	if frame == 0 {
+		if _, err := d.rs.Seek(d.dataStart, io.SeekStart); err != nil {
+			return fmt.Errorf("can't seek flac file: %s", err.Error())
+		}
+		return nil
+	}
+
+	// decoder can't seek to the very end of stream
+	if n := d.stream.Info.NSamples; n != 0 && uint64(frame) >= n {
+		d.eof = true
+		return nil
+	}
+
+	first, err := d.stream.Seek(uint64(frame))
+	if err == io.EOF {
+		d.eof = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("can't seek flac file: %s", err.Error())
+	}
+
+	// flac seeks to the beginning of the frame containing requested sample
+	skip := (frame - int(first)) * int(d.stream.Info.NChannels)
+
+	for skip > 0 {
+		if err := d.decodeFrame(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if skip >= len(d.pending) {
+			skip -= len(d.pending)
+			d.pending = nil
+		} else {
+			d.pending = d.pending[skip:]
+			skip = 0
+		}
+	}
+
+	return nil
+}
+
+func (d *flacDecoder) read(buf []int16) (int, error) {
+	if d.eof {
+		return 0, io.EOF
+	}
+
+	n := 0
+
+	for n < len(buf) {
+		if len(d.pending) == 0 {
+			if err := d.decodeFrame(); err != nil {
+				if err == io.EOF && n != 0 {
+					break
+				}
+				return n, err
+			}
+		}
+
+		count := copy(buf[n:], d.pending)
+		d.pending = d.pending[count:]
+		n += count
+	}
+
+	return n, nil
+}
+
+func (d *flacDecoder) decodeFrame() error {
+	frame, err := d.stream.ParseNext()
+	if err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return fmt.Errorf("can't decode flac frame: %s", err.Error())
+	}
+
+	channels := len(frame.Subframes)
+	blockSize := int(frame.BlockSize)
+	bitsPerSample := int(frame.BitsPerSample)
+
+	samples := make([]int16, blockSize*channels)
+
+	for ch, subframe := range frame.Subframes {
+		for i := 0; i < blockSize; i++ {
+			v := subframe.Samples[i]
+			if bitsPerSample > 16 {
+				v >>= uint(bitsPerSample - 16)
+			} else {
+				v <<= uint(16 - bitsPerSample)
+			}
+			samples[i*channels+ch] = int16(v)
+		}
+	}
+
+	d.pending = samples
+
+	return nil
+}
@@ -0,0 +1,310 @@
+package snd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// -6 dBFS, leaves some headroom for the encoder
+	generatorAmplitude = 0.5
+
+	clickLength = time.Millisecond
+	clickFreq   = 1000
+)
+
+type signalKind int
+
+const (
+	signalTone signalKind = iota
+	signalSweep
+	signalWhiteNoise
+	signalPinkNoise
+	signalSilence
+	signalClick
+)
+
+var generatorPrefixes = []string{"tone:", "sweep:", "noise:", "click:"}
+
+func isGenerator(deviceOrFile string) bool {
+	if deviceOrFile == "silence" {
+		return true
+	}
+	for _, prefix := range generatorPrefixes {
+		if strings.HasPrefix(deviceOrFile, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type signalSpec struct {
+	kind     signalKind
+	freq     float64
+	freqEnd  float64
+	duration time.Duration
+}
+
+type Generator struct {
+	spec signalSpec
+
+	rate  int
+	rng   *rand.Rand
+	pink  [7]float64
+	phase float64
+	pos   int
+
+	batchCh  chan Batch
+	cancelCh chan struct{}
+	doneCh   chan struct{}
+}
+
+func NewGenerator(params Params) (*Generator, error) {
+	spec, err := parseSignalSpec(params.DeviceOrFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid generator %q: %s", params.DeviceOrFile, err.Error())
+	}
+
+	nyquist := float64(params.Rate) / 2
+	if spec.freq >= nyquist || spec.freqEnd >= nyquist {
+		return nil, fmt.Errorf("invalid generator %q: frequency should be below %d",
+			params.DeviceOrFile, int(nyquist))
+	}
+
+	g := &Generator{
+		spec:     spec,
+		rate:     params.Rate,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		batchCh:  make(chan Batch, 64),
+		cancelCh: make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+
+	go g.runGenerating(params)
+
+	return g, nil
+}
+
+func (g *Generator) Batches() <-chan Batch {
+	return g.batchCh
+}
+
+func (g *Generator) Stop() {
+	close(g.cancelCh)
+	<-g.doneCh
+}
+
+func (g *Generator) runGenerating(params Params) {
+	defer close(g.doneCh)
+	defer close(g.batchCh)
+
+	samplesPerFramePerChan := durationToSamples(params.FrameLength, params.Rate)
+
+	limiter := rate.NewLimiter(rate.Limit(params.Rate), samplesPerFramePerChan)
+
+	for {
+		select {
+		case <-g.cancelCh:
+			return
+		default:
+		}
+
+		data := make([]int16, samplesPerFramePerChan*params.Channels)
+		n := 0
+
+		for s := 0; s < samplesPerFramePerChan; s++ {
+			sample := int16(g.nextSample() * math.MaxInt16)
+			for i := 0; i < params.Channels; i++ {
+				data[n] = sample
+				n++
+			}
+		}
+
+		limiter.WaitN(context.TODO(), samplesPerFramePerChan)
+
+		select {
+		case g.batchCh <- Batch{Data: data}:
+		case <-g.cancelCh:
+			return
+		}
+	}
+}
+
+func (g *Generator) nextSample() float64 {
+	var v float64
+
+	switch g.spec.kind {
+	case signalTone:
+		v = math.Sin(g.phase)
+		g.advancePhase(g.spec.freq)
+
+	case signalSweep:
+		// logarithmic sweep, restarted after reaching the end frequency
+		period := durationToSamples(g.spec.duration, g.rate)
+		if g.pos >= period {
+			g.pos = 0
+		}
+		t := float64(g.pos) / float64(period)
+		v = math.Sin(g.phase)
+		g.advancePhase(g.spec.freq * math.Pow(g.spec.freqEnd/g.spec.freq, t))
+
+	case signalWhiteNoise:
+		v = g.rng.Float64()*2 - 1
+
+	case signalPinkNoise:
+		v = g.pinkNoise()
+
+	case signalSilence:
+		v = 0
+
+	case signalClick:
+		// short sine burst at the beginning of every period
+		period := durationToSamples(g.spec.duration, g.rate)
+		if g.pos >= period {
+			g.pos = 0
+		}
+		if g.pos < durationToSamples(clickLength, g.rate) {
+			v = math.Sin(2 * math.Pi * clickFreq * float64(g.pos) / float64(g.rate))
+		}
+	}
+
+	g.pos++
+
+	return v * generatorAmplitude
+}
+
+func (g *Generator) advancePhase(freq float64) {
+	g.phase += 2 * math.Pi * freq / float64(g.rate)
+	if g.phase >= 2*math.Pi {
+		g.phase -= 2 * math.Pi
+	}
+}
+
+// Paul Kellet's refined pink noise filter
+func (g *Generator) pinkNoise() float64 {
+	white := g.rng.Float64()*2 - 1
+	b := &g.pink
+
+	b[0] = 0.99886*b[0] + white*0.0555179
+	b[1] = 0.99332*b[1] + white*0.0750759
+	b[2] = 0.96900*b[2] + white*0.1538520
+	b[3] = 0.86650*b[3] + white*0.3104856
+	b[4] = 0.55000*b[4] + white*0.5329522
+	b[5] = -0.7616*b[5] - white*0.0168980
+
+	v := b[0] + b[1] + b[2] + b[3] + b[4] + b[5] + b[6] + white*0.5362
+	b[6] = white * 0.115926
+
+	// filter gain is about 5, normalize to [-1; 1]
+	v *= 0.2
+	if v > 1 {
+		v = 1
+	}
+	if v < -1 {
+		v = -1
+	}
+
+	return v
+}
+
+func parseSignalSpec(s string) (signalSpec, error) {
+	fields := strings.Split(s, ":")
+
+	switch fields[0] {
+	case "tone":
+		if len(fields) != 2 {
+			return signalSpec{}, errors.New("expected 'tone:FREQ'")
+		}
+		freq, err := parseFreq(fields[1])
+		if err != nil {
+			return signalSpec{}, err
+		}
+		return signalSpec{kind: signalTone, freq: freq}, nil
+
+	case "sweep":
+		if len(fields) != 3 {
+			return signalSpec{}, errors.New("expected 'sweep:FREQ-FREQ:DURATION'")
+		}
+		freqs := strings.Split(fields[1], "-")
+		if len(freqs) != 2 {
+			return signalSpec{}, errors.New("expected 'sweep:FREQ-FREQ:DURATION'")
+		}
+		freq, err := parseFreq(freqs[0])
+		if err != nil {
+			return signalSpec{}, err
+		}
+		freqEnd, err := parseFreq(freqs[1])
+		if err != nil {
+			return signalSpec{}, err
+		}
+		duration, err := parsePeriod(fields[2])
+		if err != nil {
+			return signalSpec{}, err
+		}
+		return signalSpec{
+			kind:     signalSweep,
+			freq:     freq,
+			freqEnd:  freqEnd,
+			duration: duration,
+		}, nil
+
+	case "noise":
+		if len(fields) != 2 {
+			return signalSpec{}, errors.New("expected 'noise:white' or 'noise:pink'")
+		}
+		switch fields[1] {
+		case "white":
+			return signalSpec{kind: signalWhiteNoise}, nil
+		case "pink":
+			return signalSpec{kind: signalPinkNoise}, nil
+		default:
+			return signalSpec{}, errors.New("expected 'noise:white' or 'noise:pink'")
+		}
+
+	case "silence":
+		if len(fields) != 1 {
+			return signalSpec{}, errors.New("expected 'silence'")
+		}
+		return signalSpec{kind: signalSilence}, nil
+
+	case "click":
+		if len(fields) != 2 {
+			return signalSpec{}, errors.New("expected 'click:PERIOD'")
+		}
+		period, err := parsePeriod(fields[1])
+		if err != nil {
+			return signalSpec{}, err
+		}
+		if period < clickLength {
+			return signalSpec{}, fmt.Errorf("period should be at least %s", clickLength)
+		}
+		return signalSpec{kind: signalClick, duration: period}, nil
+
+	default:
+		return signalSpec{}, errors.New("unknown signal type")
+	}
+}
+
+func parseFreq(s string) (float64, error) {
+	freq, err := strconv.ParseFloat(s, 64)
+	if err != nil || freq <= 0 {
+		return 0, fmt.Errorf("invalid frequency %q", s)
+	}
+	return freq, nil
+}
+
+func parsePeriod(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d < time.Millisecond {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
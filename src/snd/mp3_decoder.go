@@ -0,0 +1,117 @@
+package snd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+const (
+	// decoder always produces 16-bit stereo
+	mp3Channels   = 2
+	mp3FrameBytes = 2 * mp3Channels
+)
+
+type mp3Decoder struct {
+	fp      *os.File
+	decoder *mp3.Decoder
+
+	// 1 if stereo is downmixed to mono, otherwise mp3Channels
+	channels int
+
+	buf []byte
+	eof bool
+}
+
+// if one channel is requested, decoded stereo is downmixed to mono
+func newMp3Decoder(path string, channels int) (*mp3Decoder, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open mp3 file: %s", err.Error())
+	}
+
+	decoder, err := mp3.NewDecoder(newBufReadSeeker(fp))
+	if err != nil {
+		fp.Close()
+		return nil, fmt.Errorf("can't read mp3 file header: %s", err.Error())
+	}
+
+	d := &mp3Decoder{
+		fp:       fp,
+		decoder:  decoder,
+		channels: mp3Channels,
+	}
+
+	if channels == 1 {
+		d.channels = 1
+	}
+
+	return d, nil
+}
+
+func (d *mp3Decoder) format() (int, int) {
+	return d.decoder.SampleRate(), d.channels
+}
+
+func (d *mp3Decoder) close() error {
+	return d.fp.Close()
+}
+
+func (d *mp3Decoder) seek(frame int) error {
+	pos := int64(frame) * mp3FrameBytes
+
+	// decoder can't seek to the very end of stream
+	if length := d.decoder.Length(); length >= 0 && pos >= length {
+		d.eof = true
+		return nil
+	}
+
+	d.eof = false
+
+	if _, err := d.decoder.Seek(pos, io.SeekStart); err != nil {
+		return fmt.Errorf("can't seek mp3 file: %s", err.Error())
+	}
+
+	return nil
+}
+
+func (d *mp3Decoder) read(buf []int16) (int, error) {
+	if d.eof {
+		return 0, io.EOF
+	}
+
+	size := len(buf) / d.channels * mp3FrameBytes
+	if size == 0 {
+		return 0, nil
+	}
+
+	if len(d.buf) < size {
+		d.buf = make([]byte, size)
+	}
+
+	n, err := io.ReadFull(d.decoder, d.buf[:size])
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if err != nil {
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		return 0, fmt.Errorf("can't decode mp3 frame: %s", err.Error())
+	}
+
+	n -= n % mp3FrameBytes
+
+	samples := bytesToInt16(d.buf[:n])
+
+	if d.channels == 1 {
+		for i := 0; i < len(samples)/mp3Channels; i++ {
+			buf[i] = int16((int32(samples[2*i]) + int32(samples[2*i+1])) / 2)
+		}
+		return len(samples) / mp3Channels, nil
+	}
+
+	return copy(buf, samples), nil
+}
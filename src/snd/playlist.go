@@ -0,0 +1,87 @@
+package snd
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// returns list of files to be played, for a single file it's the file itself
+func readPlaylist(path string) ([]string, error) {
+	if st, err := os.Stat(path); err == nil && st.IsDir() {
+		return readDirectory(path)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".m3u", ".m3u8":
+		return readM3U(path)
+	}
+
+	return []string{path}, nil
+}
+
+func readDirectory(path string) ([]string, error) {
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read directory: %s", err.Error())
+	}
+
+	var items []string
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(info.Name())) {
+		case ".wav", ".flac", ".mp3":
+			items = append(items, filepath.Join(path, info.Name()))
+		}
+	}
+
+	sort.Strings(items)
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no wav, flac, or mp3 files found in directory %s", path)
+	}
+
+	return items, nil
+}
+
+func readM3U(path string) ([]string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open playlist: %s", err.Error())
+	}
+	defer fp.Close()
+
+	var items []string
+
+	sc := bufio.NewScanner(fp)
+	for sc.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff"))
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// relative paths are relative to the playlist location
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(path), line)
+		}
+
+		items = append(items, line)
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("can't read playlist: %s", err.Error())
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("playlist %s is empty", path)
+	}
+
+	return items, nil
+}
@@ -0,0 +1,170 @@
+package snd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/jfreymuth/pulse/proto"
+)
+
+// used when server string doesn't specify port
+const pulseDefaultPort = "4713"
+
+type pulseServer struct {
+	network string
+	address string
+}
+
+// same as proto.Connect, but sets message callback before client starts
+// reading from connection, so that it's not changed concurrently with reading
+func connectPulse(callback func(interface{})) (*proto.Client, net.Conn, error) {
+	lastErr := errors.New("no valid server")
+
+	for _, server := range pulseServers() {
+		conn, err := net.Dial(server.network, server.address)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		client := &proto.Client{Callback: callback}
+		client.SetTimeout(time.Second)
+		client.Open(conn)
+
+		if err := authPulse(client); err != nil {
+			conn.Close()
+			lastErr = err
+			continue
+		}
+
+		return client, conn, nil
+	}
+
+	return nil, nil, lastErr
+}
+
+func authPulse(client *proto.Client) error {
+	cookie, err := readPulseCookie()
+	if err != nil {
+		return err
+	}
+
+	var reply proto.AuthReply
+	err = client.Request(&proto.Auth{
+		Version: client.Version(),
+		Cookie:  cookie,
+	}, &reply)
+	if err != nil {
+		return err
+	}
+
+	client.SetVersion(reply.Version)
+
+	return nil
+}
+
+// reads cookie from PULSE_COOKIE if it's set, otherwise from current
+// or legacy default location; if there's no cookie, returns zero cookie,
+// which is accepted if server allows anonymous auth
+func readPulseCookie() ([]byte, error) {
+	cookiePaths := []string{
+		os.Getenv("HOME") + "/.config/pulse/cookie",
+		os.Getenv("HOME") + "/.pulse-cookie",
+	}
+	if p, ok := os.LookupEnv("PULSE_COOKIE"); ok {
+		cookiePaths = []string{p}
+	}
+
+	for _, p := range cookiePaths {
+		cookie, err := ioutil.ReadFile(p)
+		if err == nil {
+			return cookie, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return make([]byte, 256), nil
+}
+
+// parses PULSE_SERVER if it's set, see pulseaudio documentation
+// on server strings, otherwise returns default server
+func pulseServers() []pulseServer {
+	env, ok := os.LookupEnv("PULSE_SERVER")
+	if !ok {
+		return defaultPulseServers()
+	}
+
+	hostname, _ := os.Hostname()
+
+	var servers []pulseServer
+	for _, s := range strings.Fields(env) {
+		// server is used only on given host
+		if strings.HasPrefix(s, "{") {
+			end := strings.IndexByte(s, '}')
+			if end < 0 || s[1:end] != hostname {
+				continue
+			}
+			s = s[end+1:]
+		}
+
+		switch {
+		case strings.HasPrefix(s, "/"):
+			servers = append(servers, pulseServer{"unix", s})
+		case strings.HasPrefix(s, "unix:"):
+			servers = append(servers, pulseServer{"unix", s[5:]})
+		case strings.HasPrefix(s, "tcp4:"):
+			servers = append(servers, pulseServer{"tcp4", pulseTCPAddress(s[5:])})
+		case strings.HasPrefix(s, "tcp6:"):
+			servers = append(servers, pulseServer{"tcp6", pulseTCPAddress(s[5:])})
+		case strings.HasPrefix(s, "tcp:"):
+			servers = append(servers, pulseServer{"tcp", pulseTCPAddress(s[4:])})
+		}
+	}
+
+	return servers
+}
+
+// adds default port if address has no port, e.g. "host" or "[::1]"
+func pulseTCPAddress(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+
+	return net.JoinHostPort(host, pulseDefaultPort)
+}
+
+func defaultPulseServers() []pulseServer {
+	switch runtime.GOOS {
+	case "linux":
+		return []pulseServer{
+			{"unix", path.Join(os.Getenv("XDG_RUNTIME_DIR"), "pulse/native")},
+		}
+
+	case "darwin":
+		u, err := user.Current()
+		if err != nil {
+			return nil
+		}
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil
+		}
+		return []pulseServer{
+			{"unix", fmt.Sprintf("%s/.config/pulse/%s-runtime/native", u.HomeDir, hostname)},
+		}
+	}
+
+	return nil
+}
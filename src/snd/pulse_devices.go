@@ -0,0 +1,76 @@
+package snd
+
+import (
+	"fmt"
+
+	"github.com/jfreymuth/pulse"
+	"github.com/jfreymuth/pulse/proto"
+)
+
+var pulseFormatNames = map[byte]string{
+	proto.FormatUint8:     "u8",
+	proto.FormatInt16LE:   "s16le",
+	proto.FormatInt16BE:   "s16be",
+	proto.FormatFloat32LE: "float32le",
+	proto.FormatFloat32BE: "float32be",
+	proto.FormatInt32LE:   "s32le",
+	proto.FormatInt32BE:   "s32be",
+}
+
+func listPulseDevices(capture bool) ([]Device, error) {
+	client, err := pulse.NewClient(pulse.ClientApplicationName("webrtc-cli"))
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to pulseaudio: %s", err.Error())
+	}
+	defer client.Close()
+
+	var server proto.GetServerInfoReply
+	if err := client.RawRequest(&proto.GetServerInfo{}, &server); err != nil {
+		return nil, fmt.Errorf("can't get pulseaudio server info: %s", err.Error())
+	}
+
+	var devices []Device
+
+	if capture {
+		var reply proto.GetSourceInfoListReply
+		if err := client.RawRequest(&proto.GetSourceInfoList{}, &reply); err != nil {
+			return nil, fmt.Errorf("can't list pulseaudio sources: %s", err.Error())
+		}
+		for _, info := range reply {
+			devices = append(devices, makePulseDevice(
+				info.SourceName, info.Device, info.SampleSpec,
+				info.SourceName == server.DefaultSourceName))
+		}
+	} else {
+		var reply proto.GetSinkInfoListReply
+		if err := client.RawRequest(&proto.GetSinkInfoList{}, &reply); err != nil {
+			return nil, fmt.Errorf("can't list pulseaudio sinks: %s", err.Error())
+		}
+		for _, info := range reply {
+			devices = append(devices, makePulseDevice(
+				info.SinkName, info.Device, info.SampleSpec,
+				info.SinkName == server.DefaultSinkName))
+		}
+	}
+
+	return devices, nil
+}
+
+func makePulseDevice(name, desc string, spec proto.SampleSpec, isDefault bool) Device {
+	format, ok := pulseFormatNames[spec.Format]
+	if !ok {
+		format = fmt.Sprintf("format%d", spec.Format)
+	}
+
+	return Device{
+		Name:        name,
+		Backend:     "pulseaudio",
+		Description: desc,
+		Default:     isDefault,
+		Formats:     []string{format},
+		MinRate:     int(spec.Rate),
+		MaxRate:     int(spec.Rate),
+		MinChannels: int(spec.Channels),
+		MaxChannels: int(spec.Channels),
+	}
+}
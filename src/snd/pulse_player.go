@@ -1,16 +1,14 @@
 package snd
 
 import (
-	"fmt"
-	"runtime"
 	"time"
-
-	"github.com/mesilliac/pulse-simple"
 )
 
+// how long to wait on stop until buffered samples are played
+const pulseDrainTimeout = 3 * time.Second
+
 type PulsePlayer struct {
-	stream   *pulse.Stream
-	initCh   chan error
+	stream   *pulseStream
 	dataCh   chan []int16
 	errCh    chan error
 	cancelCh chan struct{}
@@ -18,23 +16,20 @@ type PulsePlayer struct {
 }
 
 func NewPulsePlayer(params Params) (*PulsePlayer, error) {
+	stream, err := openPulseStream(params, false)
+	if err != nil {
+		return nil, err
+	}
+
 	p := &PulsePlayer{
-		initCh:   make(chan error, 1),
+		stream:   stream,
 		dataCh:   make(chan []int16, 0),
 		errCh:    make(chan error, 1),
 		cancelCh: make(chan struct{}),
 		doneCh:   make(chan struct{}),
 	}
 
-	go func() {
-		runtime.LockOSThread()
-		p.runPlayback(params)
-	}()
-
-	if err := <-p.initCh; err != nil {
-		<-p.doneCh
-		return nil, err
-	}
+	go p.runPlayback()
 
 	return p, nil
 }
@@ -53,49 +48,28 @@ func (p *PulsePlayer) Stopped() <-chan struct{} {
 
 func (p *PulsePlayer) Stop() {
 	close(p.cancelCh)
+	<-p.doneCh
 
-	p.waitDone()
+	// don't cut off samples still buffered on server
+	_ = p.stream.drain(pulseDrainTimeout)
 
-	if p.stream != nil {
-		p.stream.Free()
-	}
+	p.stream.close()
 }
 
-func (p *PulsePlayer) runPlayback(params Params) {
+func (p *PulsePlayer) runPlayback() {
 	defer func() {
 		close(p.doneCh)
 		close(p.errCh)
 	}()
 
-	sample_spec := pulse.SampleSpec{
-		Format:   pulse.SAMPLE_S16LE,
-		Rate:     uint32(params.Rate),
-		Channels: uint8(params.Channels),
-	}
-
-	var err error
-	p.stream, err = pulse.NewStream(
-		"", "webrtc-cli", pulse.STREAM_PLAYBACK, params.DeviceOrFile, "webrtc-cli-play",
-		&sample_spec, nil, nil)
-
-	if err != nil {
-		p.initCh <- fmt.Errorf("can't open pulseaudio playback stream: %s", err.Error())
-		return
-	}
-
-	close(p.initCh)
-
 	for {
 		var data []int16
 
-		select {
-		default:
-		case <-p.cancelCh:
-			return
-		}
-
 		select {
 		case data = <-p.dataCh:
+		case err := <-p.stream.failCh:
+			p.errCh <- err
+			return
 		case <-p.cancelCh:
 			return
 		}
@@ -104,30 +78,16 @@ func (p *PulsePlayer) runPlayback(params Params) {
 			continue
 		}
 
-		b := int16ToBytes(data)
-
-		n, err := p.stream.Write(b)
-		if err != nil {
-			p.errCh <- fmt.Errorf("can't write to pulseaudio playback stream: %s", err.Error())
+		// blocks while device is suspended, but can be canceled
+		if err := p.stream.waitRequest(p.cancelCh); err != nil {
+			if err != errCanceled {
+				p.errCh <- err
+			}
 			return
 		}
 
-		if n != len(b) {
-			panic("unexpected write size from pulseaudio")
-		}
-	}
-}
-
-func (p *PulsePlayer) waitDone() {
-	for {
-		// interrupt pa_simple_write() blocked on suspended device
-		if p.stream != nil {
-			p.stream.Flush()
-		}
-
-		select {
-		case <-time.After(time.Millisecond * 50):
-		case <-p.doneCh:
+		if err := p.stream.write(int16ToBytes(data)); err != nil {
+			p.errCh <- err
 			return
 		}
 	}
@@ -1,36 +1,26 @@
 package snd
 
-import (
-	"fmt"
-	"runtime"
-
-	"github.com/mesilliac/pulse-simple"
-)
-
 type PulseRecorder struct {
-	initCh   chan error
+	stream   *pulseStream
 	batchCh  chan Batch
 	cancelCh chan struct{}
 	doneCh   chan struct{}
 }
 
 func NewPulseRecorder(params Params) (*PulseRecorder, error) {
+	stream, err := openPulseStream(params, true)
+	if err != nil {
+		return nil, err
+	}
+
 	p := &PulseRecorder{
-		initCh:   make(chan error, 1),
+		stream:   stream,
 		batchCh:  make(chan Batch, 1),
 		cancelCh: make(chan struct{}),
 		doneCh:   make(chan struct{}),
 	}
 
-	go func() {
-		runtime.LockOSThread()
-		p.runRecording(params)
-	}()
-
-	if err := <-p.initCh; err != nil {
-		<-p.doneCh
-		return nil, err
-	}
+	go p.runRecording(params)
 
 	return p, nil
 }
@@ -41,9 +31,9 @@ func (p *PulseRecorder) Batches() <-chan Batch {
 
 func (p *PulseRecorder) Stop() {
 	close(p.cancelCh)
+	<-p.doneCh
 
-	// there is no way to interrupt pa_simple_read() blocked on suspended device
-	// so we can't wait its termination
+	p.stream.close()
 }
 
 func (p *PulseRecorder) runRecording(params Params) {
@@ -52,57 +42,38 @@ func (p *PulseRecorder) runRecording(params Params) {
 		close(p.batchCh)
 	}()
 
-	sample_spec := pulse.SampleSpec{
-		Format:   pulse.SAMPLE_S16LE,
-		Rate:     uint32(params.Rate),
-		Channels: uint8(params.Channels),
-	}
-
-	stream, err := pulse.NewStream(
-		"", "webrtc-cli", pulse.STREAM_RECORD, params.DeviceOrFile, "webrtc-cli-record",
-		&sample_spec, nil, nil)
-
-	if err != nil {
-		p.initCh <- fmt.Errorf("can't open pulseaudio record stream: %s", err.Error())
-		return
-	}
-
-	close(p.initCh)
-
-	defer stream.Free()
+	frameBytes := durationToSamples(params.FrameLength, params.Rate) * p.stream.frameBytes
 
-	frameBytes := durationToSamples(params.FrameLength, params.Rate) *
-		int(sample_spec.FrameSize())
+	var buf []byte
 
 	for {
 		select {
-		case <-p.cancelCh:
-			return
-		default:
-		}
-
-		data := make([]byte, frameBytes)
+		case data := <-p.stream.dataCh:
+			buf = append(buf, data...)
 
-		n, err := stream.Read(data)
-		if err != nil {
-			p.batchCh <- Batch{
-				Err: fmt.Errorf("can't read from pulseaudio record stream: %s", err.Error()),
+		case err := <-p.stream.failCh:
+			select {
+			case p.batchCh <- Batch{Err: err}:
+			case <-p.cancelCh:
 			}
 			return
-		}
 
-		if n == 0 || n > len(data) {
-			panic("unexpected read size from pulseaudio")
+		case <-p.cancelCh:
+			return
 		}
 
-		batch := Batch{
-			Data: bytesToInt16(data),
-		}
+		// server fragments don't match our frames
+		for len(buf) >= frameBytes {
+			batch := Batch{
+				Data: bytesToInt16(buf[:frameBytes]),
+			}
+			buf = buf[frameBytes:]
 
-		select {
-		case p.batchCh <- batch:
-		case <-p.cancelCh:
-			return
+			select {
+			case p.batchCh <- batch:
+			case <-p.cancelCh:
+				return
+			}
 		}
 	}
 }
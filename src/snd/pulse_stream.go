@@ -0,0 +1,340 @@
+package snd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jfreymuth/pulse/proto"
+
+	"github.com/gavv/webrtc-cli/src/log"
+)
+
+var pulseLog = log.New("pulse")
+
+// PA_VOLUME_NORM
+const pulseVolumeNorm = 0x10000
+
+var errCanceled = errors.New("canceled")
+
+// asynchronous pulseaudio record or playback stream, using native protocol;
+// server events are delivered from the connection goroutine via channels
+type pulseStream struct {
+	client *proto.Client
+	conn   net.Conn
+
+	index   uint32
+	capture bool
+
+	frameBytes int
+
+	mu        sync.Mutex
+	ready     bool
+	requested int
+
+	// recorded data, capture only
+	dataCh chan []byte
+
+	// server requested more data, playback only
+	requestCh chan struct{}
+
+	// stream can't be used anymore
+	failCh chan error
+
+	closeOnce sync.Once
+}
+
+func openPulseStream(params Params, capture bool) (*pulseStream, error) {
+	s := &pulseStream{
+		capture:    capture,
+		frameBytes: params.Channels * 2,
+		dataCh:     make(chan []byte, 64),
+		requestCh:  make(chan struct{}, 1),
+		failCh:     make(chan error, 1),
+	}
+
+	var err error
+	s.client, s.conn, err = connectPulse(s.handleMessage)
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to pulseaudio: %s", err.Error())
+	}
+
+	err = s.client.Request(&proto.SetClientName{
+		Props: proto.PropList{
+			"application.name": proto.PropListString("webrtc-cli"),
+		},
+	}, &proto.SetClientNameReply{})
+	if err != nil {
+		s.conn.Close()
+		return nil, fmt.Errorf("can't set pulseaudio client name: %s", err.Error())
+	}
+
+	if err := s.create(params); err != nil {
+		s.conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *pulseStream) create(params Params) error {
+	spec := proto.SampleSpec{
+		Format:   proto.FormatInt16LE,
+		Channels: byte(params.Channels),
+		Rate:     uint32(params.Rate),
+	}
+
+	chanMap := proto.ChannelMap{proto.ChannelMono}
+	if params.Channels == 2 {
+		chanMap = proto.ChannelMap{proto.ChannelLeft, proto.ChannelRight}
+	}
+
+	volumes := make(proto.ChannelVolumes, params.Channels)
+	for n := range volumes {
+		volumes[n] = pulseVolumeNorm
+	}
+
+	// requested latency, server may adjust it
+	bufferBytes := uint32(proto.Undefined)
+	if params.PulseBufferLength > 0 {
+		bufferBytes = uint32(durationToSamples(params.PulseBufferLength, params.Rate) *
+			s.frameBytes)
+	}
+
+	// created corked and uncorked after we know stream index,
+	// so that no data or requests are missed
+	if s.capture {
+		var reply proto.CreateRecordStreamReply
+
+		err := s.client.Request(&proto.CreateRecordStream{
+			SampleSpec:         spec,
+			ChannelMap:         chanMap,
+			SourceIndex:        proto.Undefined,
+			SourceName:         params.DeviceOrFile,
+			BufferMaxLength:    proto.Undefined,
+			Corked:             true,
+			BufferFragSize:     bufferBytes,
+			AdjustLatency:      true,
+			Properties:         pulseStreamProps("webrtc-cli-record"),
+			DirectOnInputIndex: proto.Undefined,
+			ChannelVolumes:     volumes,
+		}, &reply)
+		if err != nil {
+			return fmt.Errorf("can't open pulseaudio record stream: %s", err.Error())
+		}
+
+		s.setReady(reply.StreamIndex, 0)
+
+		err = s.client.Request(&proto.CorkRecordStream{
+			StreamIndex: s.index,
+			Corked:      false,
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("can't start pulseaudio record stream: %s", err.Error())
+		}
+	} else {
+		var reply proto.CreatePlaybackStreamReply
+
+		err := s.client.Request(&proto.CreatePlaybackStream{
+			SampleSpec:            spec,
+			ChannelMap:            chanMap,
+			SinkIndex:             proto.Undefined,
+			SinkName:              params.DeviceOrFile,
+			BufferMaxLength:       proto.Undefined,
+			Corked:                true,
+			BufferTargetLength:    bufferBytes,
+			BufferPrebufferLength: proto.Undefined,
+			BufferMinimumRequest:  proto.Undefined,
+			ChannelVolumes:        volumes,
+			AdjustLatency:         true,
+			Properties:            pulseStreamProps("webrtc-cli-play"),
+		}, &reply)
+		if err != nil {
+			return fmt.Errorf("can't open pulseaudio playback stream: %s", err.Error())
+		}
+
+		s.setReady(reply.StreamIndex, int(reply.Missing))
+
+		err = s.client.Request(&proto.CorkPlaybackStream{
+			StreamIndex: s.index,
+			Corked:      false,
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("can't start pulseaudio playback stream: %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+func pulseStreamProps(name string) proto.PropList {
+	return proto.PropList{
+		"media.name": proto.PropListString(name),
+	}
+}
+
+func (s *pulseStream) setReady(index uint32, missing int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.index = index
+	s.ready = true
+	s.requested += missing
+}
+
+// deletes stream and closes connection, interrupts any pending operation
+func (s *pulseStream) close() {
+	s.closeOnce.Do(func() {
+		if s.capture {
+			_ = s.client.Request(&proto.DeleteRecordStream{StreamIndex: s.index}, nil)
+		} else {
+			_ = s.client.Request(&proto.DeletePlaybackStream{StreamIndex: s.index}, nil)
+		}
+		s.conn.Close()
+	})
+}
+
+// waits until server requests more data, playback only
+func (s *pulseStream) waitRequest(cancelCh <-chan struct{}) error {
+	for {
+		s.mu.Lock()
+		requested := s.requested
+		s.mu.Unlock()
+
+		if requested > 0 {
+			return nil
+		}
+
+		select {
+		case <-s.requestCh:
+		case err := <-s.failCh:
+			return err
+		case <-cancelCh:
+			return errCanceled
+		}
+	}
+}
+
+// waits until server plays all written data, playback only;
+// gives up if stream fails or timeout expires, e.g. when device is suspended
+func (s *pulseStream) drain(timeout time.Duration) error {
+	// no other requests are running on this connection during drain
+	s.client.SetTimeout(timeout)
+
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- s.client.Request(&proto.DrainPlaybackStream{StreamIndex: s.index}, nil)
+	}()
+
+	select {
+	case err := <-doneCh:
+		if err != nil {
+			return fmt.Errorf("can't drain pulseaudio playback stream: %s", err.Error())
+		}
+		return nil
+	case err := <-s.failCh:
+		return err
+	}
+}
+
+// sends data without blocking, playback only
+func (s *pulseStream) write(b []byte) error {
+	s.mu.Lock()
+	s.requested -= len(b)
+	s.mu.Unlock()
+
+	if err := s.client.Send(s.index, b); err != nil {
+		return fmt.Errorf("can't write to pulseaudio playback stream: %s", err.Error())
+	}
+
+	return nil
+}
+
+// called from connection goroutine
+func (s *pulseStream) handleMessage(msg interface{}) {
+	s.mu.Lock()
+	ready, index := s.ready, s.index
+	s.mu.Unlock()
+
+	if _, ok := msg.(*proto.ConnectionClosed); ok {
+		s.fail(errors.New("pulseaudio server closed connection"))
+		return
+	}
+
+	if !ready {
+		return
+	}
+
+	switch msg := msg.(type) {
+	case *proto.DataPacket:
+		if msg.StreamIndex != index {
+			return
+		}
+		// buffer is reused by connection goroutine
+		data := make([]byte, len(msg.Data))
+		copy(data, msg.Data)
+
+		// drop data if reader is too slow, like server does on overrun
+		select {
+		case s.dataCh <- data:
+		default:
+		}
+
+	case *proto.Request:
+		if msg.StreamIndex != index {
+			return
+		}
+		s.mu.Lock()
+		s.requested += int(msg.Length)
+		s.mu.Unlock()
+
+		select {
+		case s.requestCh <- struct{}{}:
+		default:
+		}
+
+	case *proto.RecordStreamKilled:
+		if msg.StreamIndex == index {
+			s.fail(errors.New("pulseaudio record stream was killed, device removed?"))
+		}
+
+	case *proto.PlaybackStreamKilled:
+		if msg.StreamIndex == index {
+			s.fail(errors.New("pulseaudio playback stream was killed, device removed?"))
+		}
+
+	case *proto.RecordStreamSuspended:
+		if msg.StreamIndex == index && msg.Suspended {
+			s.fail(errors.New("pulseaudio source was suspended"))
+		}
+
+	case *proto.PlaybackStreamSuspended:
+		if msg.StreamIndex == index && msg.Suspended {
+			s.fail(errors.New("pulseaudio sink was suspended"))
+		}
+
+	// server keeps the stream running on another device, e.g. when
+	// user switches output or device is unplugged and there's a fallback
+	case *proto.RecordStreamMoved:
+		if msg.StreamIndex == index {
+			pulseLog.Info("stream_moved", log.Fields{"device": msg.DestName},
+				"pulseaudio record stream was moved to %s", msg.DestName)
+		}
+
+	case *proto.PlaybackStreamMoved:
+		if msg.StreamIndex == index {
+			pulseLog.Info("stream_moved", log.Fields{"device": msg.DestName},
+				"pulseaudio playback stream was moved to %s", msg.DestName)
+		}
+	}
+}
+
+// reports only first error
+func (s *pulseStream) fail(err error) {
+	select {
+	case s.failCh <- err:
+	default:
+	}
+}
@@ -5,10 +5,20 @@ import (
 )
 
 type Params struct {
-	DeviceOrFile string
-	Rate         int
-	Channels     int
-	FrameLength  time.Duration
+	DeviceOrFile      string
+	Rate              int
+	Channels          int
+	FrameLength       time.Duration
+	BufferLength      time.Duration
+	PeriodLength      time.Duration
+	PulseBufferLength time.Duration
+	Loop              bool
+	Shuffle           bool
+	Start             time.Duration
+	Duration          time.Duration
+	Gap               time.Duration
+	// write files as fast as possible instead of in real time
+	Unpaced bool
 }
 
 type Batch struct {
@@ -21,9 +31,49 @@ type Reader interface {
 	Stop()
 }
 
+type Writer interface {
+	Batches() chan<- []int16
+	Errors() <-chan error
+	Stopped() <-chan struct{}
+	Stop()
+}
+
+// "default" and device indices take precedence over files, so a file with
+// such name should be given with explicit path, e.g. "./default"
 func NewReader(params Params) (Reader, error) {
-	if isWavFile(params.DeviceOrFile) {
-		return NewWavReader(params)
+	if isDeviceAlias(params.DeviceOrFile) {
+		device, err := resolveDeviceAlias(params.DeviceOrFile, true)
+		if err != nil {
+			return nil, err
+		}
+		params.DeviceOrFile = device
+	}
+	if isGenerator(params.DeviceOrFile) {
+		return NewGenerator(params)
+	}
+	if isAlsaDevice(params.DeviceOrFile) {
+		return NewAlsaRecorder(params)
+	}
+	if isFile(params.DeviceOrFile) {
+		return NewFileReader(params)
 	}
 	return NewPulseRecorder(params)
 }
+
+// same as NewReader, "default" and device indices take precedence over files
+func NewWriter(params Params) (Writer, error) {
+	if isDeviceAlias(params.DeviceOrFile) {
+		device, err := resolveDeviceAlias(params.DeviceOrFile, false)
+		if err != nil {
+			return nil, err
+		}
+		params.DeviceOrFile = device
+	}
+	if isAlsaDevice(params.DeviceOrFile) {
+		return NewAlsaPlayer(params)
+	}
+	if isOutputFile(params.DeviceOrFile) {
+		return NewFileWriter(params)
+	}
+	return NewPulsePlayer(params)
+}
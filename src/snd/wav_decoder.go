@@ -0,0 +1,252 @@
+package snd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatIEEEFloat  = 0x0003
+	wavFormatExtensible = 0xFFFE
+)
+
+type wavDecoder struct {
+	fp *os.File
+	rd *bufio.Reader
+
+	audioFormat    int
+	rate           int
+	channels       int
+	blockAlign     int
+	bytesPerSample int
+
+	dataOffset int64
+	dataSize   int64
+	dataPos    int64
+
+	block []byte
+}
+
+func newWavDecoder(path string) (*wavDecoder, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open wav file: %s", err.Error())
+	}
+
+	d := &wavDecoder{
+		fp: fp,
+		rd: bufio.NewReader(fp),
+	}
+
+	if err := d.readHeader(); err != nil {
+		fp.Close()
+		return nil, fmt.Errorf("can't read wav file header: %s", err.Error())
+	}
+
+	if err := d.seek(0); err != nil {
+		fp.Close()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *wavDecoder) format() (int, int) {
+	return d.rate, d.channels
+}
+
+func (d *wavDecoder) close() error {
+	return d.fp.Close()
+}
+
+func (d *wavDecoder) seek(frame int) error {
+	pos := int64(frame) * int64(d.blockAlign)
+	if pos > d.dataSize {
+		pos = d.dataSize
+	}
+
+	if _, err := d.fp.Seek(d.dataOffset+pos, io.SeekStart); err != nil {
+		return fmt.Errorf("can't seek wav file: %s", err.Error())
+	}
+
+	d.rd.Reset(d.fp)
+	d.dataPos = pos
+
+	return nil
+}
+
+func (d *wavDecoder) read(buf []int16) (int, error) {
+	n := 0
+
+	for n+d.channels <= len(buf) {
+		if d.dataPos+int64(d.blockAlign) > d.dataSize {
+			break
+		}
+
+		if _, err := io.ReadFull(d.rd, d.block); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// truncated file, data chunk size is wrong
+				d.dataSize = d.dataPos
+				break
+			}
+			return n, fmt.Errorf("can't read from wav file: %s", err.Error())
+		}
+
+		d.dataPos += int64(d.blockAlign)
+
+		for ch := 0; ch < d.channels; ch++ {
+			buf[n] = d.convertSample(d.block[ch*d.bytesPerSample:])
+			n++
+		}
+	}
+
+	if n == 0 && len(buf) >= d.channels {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+func (d *wavDecoder) convertSample(b []byte) int16 {
+	if d.audioFormat == wavFormatIEEEFloat {
+		var v float64
+		if d.bytesPerSample == 4 {
+			v = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		} else {
+			v = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+		return floatToInt16(v)
+	}
+
+	switch d.bytesPerSample {
+	case 1:
+		// 8-bit samples are unsigned
+		return int16(int(b[0])-128) << 8
+	case 2:
+		return int16(binary.LittleEndian.Uint16(b))
+	case 3:
+		return int16(int32(uint32(b[1])<<16|uint32(b[2])<<24) >> 16)
+	default:
+		return int16(int32(binary.LittleEndian.Uint32(b)) >> 16)
+	}
+}
+
+func (d *wavDecoder) readHeader() error {
+	var riff [12]byte
+	if _, err := io.ReadFull(d.fp, riff[:]); err != nil {
+		return err
+	}
+
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return errors.New("not a RIFF/WAVE file")
+	}
+
+	offset := int64(len(riff))
+	foundFormat := false
+
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(d.fp, hdr[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return errors.New("data chunk not found")
+			}
+			return err
+		}
+
+		chunkID := string(hdr[0:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(hdr[4:8]))
+
+		offset += int64(len(hdr))
+
+		switch chunkID {
+		case "fmt ":
+			b := make([]byte, chunkSize)
+			if _, err := io.ReadFull(d.fp, b); err != nil {
+				return err
+			}
+			if err := d.parseFormat(b); err != nil {
+				return err
+			}
+			foundFormat = true
+
+		case "data":
+			if !foundFormat {
+				return errors.New("format chunk not found before data chunk")
+			}
+
+			d.dataOffset = offset
+			d.dataSize = chunkSize
+
+			// streamed files may have unknown or wrong data size
+			if st, err := d.fp.Stat(); err == nil {
+				if d.dataOffset+d.dataSize > st.Size() {
+					d.dataSize = st.Size() - d.dataOffset
+				}
+			}
+
+			d.dataSize -= d.dataSize % int64(d.blockAlign)
+
+			return nil
+		}
+
+		offset += chunkSize + chunkSize%2
+
+		if _, err := d.fp.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *wavDecoder) parseFormat(b []byte) error {
+	if len(b) < 16 {
+		return errors.New("format chunk is too short")
+	}
+
+	d.audioFormat = int(binary.LittleEndian.Uint16(b[0:2]))
+	d.channels = int(binary.LittleEndian.Uint16(b[2:4]))
+	d.rate = int(binary.LittleEndian.Uint32(b[4:8]))
+	d.blockAlign = int(binary.LittleEndian.Uint16(b[12:14]))
+
+	bitsPerSample := int(binary.LittleEndian.Uint16(b[14:16]))
+
+	if d.audioFormat == wavFormatExtensible {
+		// actual format is stored in first two bytes of sub-format GUID
+		if len(b) < 26 {
+			return errors.New("extensible format chunk is too short")
+		}
+		d.audioFormat = int(binary.LittleEndian.Uint16(b[24:26]))
+	}
+
+	d.bytesPerSample = (bitsPerSample + 7) / 8
+
+	switch d.audioFormat {
+	case wavFormatPCM:
+		if d.bytesPerSample < 1 || d.bytesPerSample > 4 {
+			return fmt.Errorf("unsupported pcm sample size %d bits", bitsPerSample)
+		}
+	case wavFormatIEEEFloat:
+		if d.bytesPerSample != 4 && d.bytesPerSample != 8 {
+			return fmt.Errorf("unsupported float sample size %d bits", bitsPerSample)
+		}
+	default:
+		return fmt.Errorf("unsupported audio format 0x%04x", d.audioFormat)
+	}
+
+	if d.channels == 0 || d.rate == 0 {
+		return errors.New("invalid format chunk")
+	}
+
+	if d.blockAlign < d.channels*d.bytesPerSample {
+		return errors.New("invalid block align")
+	}
+
+	d.block = make([]byte, d.blockAlign)
+
+	return nil
+}
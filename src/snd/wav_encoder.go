@@ -0,0 +1,108 @@
+package snd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const wavHeaderLen = 44
+
+// writes 16-bit PCM wav file, chunk sizes are updated on close
+type wavEncoder struct {
+	fp *os.File
+	wr *bufio.Writer
+
+	rate     int
+	channels int
+
+	dataSize int64
+}
+
+func newWavEncoder(path string, rate, channels int) (*wavEncoder, error) {
+	fp, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open wav file: %s", err.Error())
+	}
+
+	e := &wavEncoder{
+		fp:       fp,
+		wr:       bufio.NewWriter(fp),
+		rate:     rate,
+		channels: channels,
+	}
+
+	if err := e.writeHeader(); err != nil {
+		fp.Close()
+		return nil, fmt.Errorf("can't write wav file header: %s", err.Error())
+	}
+
+	return e, nil
+}
+
+func (e *wavEncoder) write(buf []int16) error {
+	if _, err := e.wr.Write(int16ToBytes(buf)); err != nil {
+		return fmt.Errorf("can't write to wav file: %s", err.Error())
+	}
+
+	e.dataSize += int64(len(buf) * 2)
+
+	return nil
+}
+
+func (e *wavEncoder) close() error {
+	err := e.finish()
+	if cerr := e.fp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return fmt.Errorf("can't close wav file: %s", err.Error())
+	}
+
+	return nil
+}
+
+func (e *wavEncoder) finish() error {
+	if err := e.wr.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := e.fp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return e.writeHeader()
+}
+
+func (e *wavEncoder) writeHeader() error {
+	hdr := make([]byte, wavHeaderLen)
+
+	blockAlign := e.channels * 2
+
+	copy(hdr[0:], "RIFF")
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(wavHeaderLen-8+e.dataSize))
+	copy(hdr[8:], "WAVE")
+
+	copy(hdr[12:], "fmt ")
+	binary.LittleEndian.PutUint32(hdr[16:], 16)
+	binary.LittleEndian.PutUint16(hdr[20:], wavFormatPCM)
+	binary.LittleEndian.PutUint16(hdr[22:], uint16(e.channels))
+	binary.LittleEndian.PutUint32(hdr[24:], uint32(e.rate))
+	binary.LittleEndian.PutUint32(hdr[28:], uint32(e.rate*blockAlign))
+	binary.LittleEndian.PutUint16(hdr[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(hdr[34:], 16)
+
+	copy(hdr[36:], "data")
+	binary.LittleEndian.PutUint32(hdr[40:], uint32(e.dataSize))
+
+	if _, err := e.fp.Write(hdr); err != nil {
+		return err
+	}
+
+	// following writes go after header
+	_, err := e.fp.Seek(0, io.SeekEnd)
+	return err
+}
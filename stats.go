@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gavv/webrtc-cli/src/dsp"
+	"github.com/gavv/webrtc-cli/src/log"
+	"github.com/gavv/webrtc-cli/src/quality"
+	"github.com/gavv/webrtc-cli/src/rtc"
+)
+
+// sources of call statistics, jitter buffer is set when playback starts
+type statsSources struct {
+	mu        sync.Mutex
+	peers     []*rtc.Peer
+	peerNames []string
+	jitbuf    *dsp.JitterBuf
+
+	// used to estimate end-to-end delay
+	sinkFrame time.Duration
+}
+
+// call statistics of one peer, with estimated quality of both directions
+type peerStats struct {
+	rtc.Stats
+
+	SendQuality    quality.Score
+	ReceiveQuality quality.Score
+}
+
+func (s *statsSources) setJitterBuf(jitbuf *dsp.JitterBuf) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jitbuf = jitbuf
+}
+
+// if final is set, quality is estimated from loss over the whole session,
+// otherwise from loss during last RTCP interval
+func (s *statsSources) peerStats(n int, final bool) peerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.peerStatsLocked(n, final)
+}
+
+func (s *statsSources) peerStatsLocked(n int, final bool) peerStats {
+	stats := peerStats{Stats: s.peers[n].Stats()}
+
+	// buffering of remote peer isn't known, assume that
+	// its jitter buffer is twice the jitter
+	stats.SendQuality = estimateQuality(stats.Send, stats.RTT,
+		2*stats.Send.Jitter, sentLoss(stats.Send, final))
+
+	// only peer with sink reads from jitter buffer, other peers
+	// don't receive anything
+	buffering := 2 * stats.Receive.Jitter
+	if s.jitbuf != nil {
+		buffering = s.jitbuf.Stats().TargetLength + s.sinkFrame
+	}
+
+	stats.ReceiveQuality = estimateQuality(stats.Receive, stats.RTT,
+		buffering, receivedLoss(stats.Receive, final))
+
+	return stats
+}
+
+// mouth-to-ear delay consists of network delay, packetization and codec delay,
+// and receiver buffering; delays of audio devices are not taken into account;
+// network delay is half of RTT, and is unknown until remote peer reports RTT
+// (receive-only peer never gets it, since it doesn't send sender reports)
+func estimateQuality(
+	stats rtc.StreamStats, rtt, buffering time.Duration, loss float64,
+) quality.Score {
+	delay := rtt/2 + stats.FrameLength + quality.Opus.Lookahead + buffering
+
+	return quality.Estimate(quality.Input{
+		Codec: quality.Opus,
+		Loss:  loss,
+		Delay: delay,
+	})
+}
+
+func sentLoss(stats rtc.StreamStats, final bool) float64 {
+	if !final {
+		return stats.FractionLost
+	}
+	if stats.Packets == 0 || stats.CumulativeLost <= 0 {
+		return 0
+	}
+	return float64(stats.CumulativeLost) / float64(stats.Packets)
+}
+
+func receivedLoss(stats rtc.StreamStats, final bool) float64 {
+	if !final {
+		return stats.FractionLost
+	}
+	if stats.CumulativeLost <= 0 {
+		return 0
+	}
+	return float64(stats.CumulativeLost) /
+		float64(stats.Packets+uint64(stats.CumulativeLost))
+}
+
+func printStats(stats peerStats, peerName string) {
+	if s := stats.Send; s.Packets != 0 {
+		fields := streamStatsFields(s, "send")
+		msg := fmt.Sprintf("Sent %d packets (%d bytes, %.1f kbit/s)",
+			s.Packets, s.Bytes, s.Bitrate/1000)
+		if s.Reported {
+			addQualityFields(fields, stats.SendQuality, stats.RTT)
+			msg += fmt.Sprintf(", remote reports %.1f%% loss (%d lost), jitter %s",
+				s.FractionLost*100, s.CumulativeLost, s.Jitter.Round(time.Microsecond))
+		} else {
+			// not known until remote peer reports it
+			delete(fields, "fraction_lost")
+			delete(fields, "cumulative_lost")
+			delete(fields, "jitter_ms")
+		}
+		if stats.RTT != 0 {
+			fields["rtt_ms"] = log.Millis(stats.RTT)
+			msg += fmt.Sprintf(", RTT %s", stats.RTT.Round(time.Microsecond))
+		}
+		if s.Reported {
+			msg += ", " + qualityString(stats.SendQuality, stats.RTT)
+		}
+		mainLog.Info("stats", peerFields(peerName, fields), "%s%s", msg, peerLabel(peerName))
+	}
+
+	if s := stats.Receive; s.Packets != 0 {
+		fields := streamStatsFields(s, "receive")
+		addQualityFields(fields, stats.ReceiveQuality, stats.RTT)
+		mainLog.Info("stats", peerFields(peerName, fields),
+			"Received %d packets (%d bytes, %.1f kbit/s), %.1f%% loss (%d lost), jitter %s, %s%s",
+			s.Packets, s.Bytes, s.Bitrate/1000, s.FractionLost*100, s.CumulativeLost,
+			s.Jitter.Round(time.Microsecond), qualityString(stats.ReceiveQuality, stats.RTT),
+			peerLabel(peerName))
+	}
+}
+
+// printed at exit, uses totals over the whole session
+func printReport(stats peerStats, peerName string) {
+	if s := stats.Send; s.Packets != 0 {
+		fields := log.Fields{
+			"direction": "send",
+			"packets":   s.Packets,
+			"bytes":     s.Bytes,
+		}
+		msg := fmt.Sprintf("Session report: sent %d packets (%d bytes)", s.Packets, s.Bytes)
+		if s.Reported {
+			fields["cumulative_lost"] = s.CumulativeLost
+			addQualityFields(fields, stats.SendQuality, stats.RTT)
+			msg += fmt.Sprintf(", remote reports %d lost (%.1f%%), %s",
+				s.CumulativeLost, sentLoss(s, true)*100,
+				qualityString(stats.SendQuality, stats.RTT))
+		}
+		mainLog.Info("session_report", peerFields(peerName, fields),
+			"%s%s", msg, peerLabel(peerName))
+	}
+
+	if s := stats.Receive; s.Packets != 0 {
+		fields := log.Fields{
+			"direction":       "receive",
+			"packets":         s.Packets,
+			"bytes":           s.Bytes,
+			"cumulative_lost": s.CumulativeLost,
+		}
+		addQualityFields(fields, stats.ReceiveQuality, stats.RTT)
+		mainLog.Info("session_report", peerFields(peerName, fields),
+			"Session report: received %d packets (%d bytes), %d lost (%.1f%%), %s%s",
+			s.Packets, s.Bytes, s.CumulativeLost, receivedLoss(s, true)*100,
+			qualityString(stats.ReceiveQuality, stats.RTT), peerLabel(peerName))
+	}
+}
+
+func streamStatsFields(s rtc.StreamStats, direction string) log.Fields {
+	return log.Fields{
+		"direction":       direction,
+		"packets":         s.Packets,
+		"bytes":           s.Bytes,
+		"bitrate":         s.Bitrate,
+		"fraction_lost":   s.FractionLost,
+		"cumulative_lost": s.CumulativeLost,
+		"jitter_ms":       log.Millis(s.Jitter),
+	}
+}
+
+func addQualityFields(fields log.Fields, score quality.Score, rtt time.Duration) {
+	fields["r_factor"] = score.R
+	fields["mos"] = score.MOS
+	fields["network_delay_known"] = rtt != 0
+}
+
+// without RTT, quality is estimated as if network delay was zero
+func qualityString(score quality.Score, rtt time.Duration) string {
+	if rtt == 0 {
+		return score.String() + " (network delay unknown)"
+	}
+	return score.String()
+}
+
+// suffix for messages in loopback mode
+func peerLabel(peerName string) string {
+	if peerName == "" {
+		return ""
+	}
+	return " (" + peerName + " peer)"
+}
+
+func peerFields(peerName string, fields log.Fields) log.Fields {
+	if peerName != "" {
+		fields["peer"] = peerName
+	}
+	return fields
+}